@@ -0,0 +1,183 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package analyzer provides a [go/analysis] analyzer which reports Go files
+// which are not formatted with gofumpt, with suggested fixes to format them.
+//
+// It can be used with drivers like multichecker or golangci-lint,
+// which avoids having to run the gofumpt tool separately.
+package analyzer
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	goversion "go/version"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+
+	"mvdan.cc/gofumpt/format"
+)
+
+// Analyzer reports the regions of each file which gofumpt would change,
+// one diagnostic per changed region, each with a suggested fix.
+//
+// The options for each file are found like the gofumpt tool does,
+// via [format.OptionsForFile], and then updated from the analysis pass:
+// the module path and Go version are taken from the package's module,
+// or the file's own Go version from a build constraint, when available.
+// The analyzer's flags take precedence, just like the gofumpt tool's flags.
+//
+// Like the gofumpt tool when walking directories, generated files are skipped.
+var Analyzer = &analysis.Analyzer{
+	Name: "gofumpt",
+	Doc:  "report Go files which are not formatted with gofumpt",
+	URL:  "https://github.com/mvdan/gofumpt",
+	Run:  run,
+}
+
+var (
+	extraRules   format.Extra
+	disableRules format.Rules
+	lineLength   int
+	localPrefix  string
+)
+
+func init() {
+	fs := &Analyzer.Flags
+	fs.Var(&extraRules, "extra", "enable extra rules, e.g. -extra=group_params,clothe_returns")
+	fs.Var(&disableRules, "disable", "disable rules, e.g. -disable=short-decl,decl-group-many")
+	fs.IntVar(&lineLength, "line-length", 0, "line length for -extra=split_long_lines (default 100)")
+	fs.StringVar(&localPrefix, "local", "", "comma-separated import path prefixes to group last")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	setFlags := make(map[string]bool)
+	pass.Analyzer.Flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	for _, file := range pass.Files {
+		if ast.IsGenerated(file) {
+			continue
+		}
+		filename := pass.Fset.File(file.FileStart).Name()
+		if !strings.HasSuffix(filename, ".go") {
+			continue // e.g. a cgo file which was translated from a different name
+		}
+		opts, err := fileOptions(pass, file, filename, setFlags)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFile(pass, file, filename, opts); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// fileOptions returns the gofumpt options to format a file in the pass.
+func fileOptions(pass *analysis.Pass, file *ast.File, filename string, setFlags map[string]bool) (format.Options, error) {
+	opts, err := format.OptionsForFile(filename)
+	if err != nil {
+		return format.Options{}, err
+	}
+	if mod := pass.Module; mod != nil {
+		if mod.Path != "" {
+			opts.ModulePath = mod.Path
+		}
+		if v := goVersion(mod.GoVersion); v != "" {
+			opts.LangVersion = v
+		}
+	}
+	if pass.TypesInfo != nil {
+		if v := goVersion(pass.TypesInfo.FileVersions[file]); v != "" {
+			opts.LangVersion = v
+		}
+	}
+	if setFlags["extra"] {
+		opts.Extra = extraRules
+	}
+	if setFlags["disable"] {
+		opts.Disable = disableRules
+	}
+	if setFlags["line-length"] {
+		opts.LineLength = lineLength
+	}
+	if setFlags["local"] {
+		opts.LocalPrefixes = nil
+		if localPrefix != "" {
+			opts.LocalPrefixes = strings.Split(localPrefix, ",")
+		}
+	}
+	return opts, nil
+}
+
+// goVersion returns v as a valid Go version like "go1.22",
+// or an empty string if v is not a Go version.
+// Some drivers omit the "go" prefix.
+func goVersion(v string) string {
+	if !strings.HasPrefix(v, "go") {
+		v = "go" + v
+	}
+	if !goversion.IsValid(v) {
+		return ""
+	}
+	return v
+}
+
+// checkFile reports a diagnostic for each region of a file which gofumpt
+// would change. Since the syntax trees in a pass are shared and must not be
+// modified, we format a fresh copy of the file's source.
+func checkFile(pass *analysis.Pass, file *ast.File, filename string, opts format.Options) error {
+	src, err := pass.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	edits, err := format.Edits(src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	// The rules' diagnostics help describe each change,
+	// but changes made by gofmt itself are not reported by them.
+	diags, err := format.Check(src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	tokFile := pass.Fset.File(file.FileStart)
+	for _, edit := range edits {
+		diag := analysis.Diagnostic{
+			Pos:     tokFile.Pos(edit.Start),
+			End:     tokFile.Pos(edit.End),
+			Message: "file is not gofumpt-ed",
+			SuggestedFixes: []analysis.SuggestedFix{{
+				Message: "Format with gofumpt",
+				TextEdits: []analysis.TextEdit{{
+					Pos:     tokFile.Pos(edit.Start),
+					End:     tokFile.Pos(edit.End),
+					NewText: []byte(edit.New),
+				}},
+			}},
+		}
+		if d, ok := ruleDiagnostic(diags, tokFile.Line(diag.Pos), tokFile.Line(diag.End)); ok {
+			diag.Category = d.Rule
+			diag.URL = "#added-rules"
+			diag.Message = fmt.Sprintf("file is not gofumpt-ed: %s", d.Message)
+		}
+		pass.Report(diag)
+	}
+	return nil
+}
+
+// ruleDiagnostic returns the first rule diagnostic whose lines
+// overlap with the lines from startLine to endLine.
+func ruleDiagnostic(diags []format.Diagnostic, startLine, endLine int) (format.Diagnostic, bool) {
+	for _, d := range diags {
+		if d.Pos.Line <= endLine && d.End.Line >= startLine {
+			return d, true
+		}
+	}
+	return format.Diagnostic{}, false
+}
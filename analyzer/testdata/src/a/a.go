@@ -0,0 +1,12 @@
+package a
+
+const j = 022 // want `file is not gofumpt-ed: octal literal prefixed`
+
+func f() {
+	var x = 1 // want `file is not gofumpt-ed: var declaration replaced with short assignment`
+	println(x)
+}
+
+func g() {
+	println(1+ 2) // want `file is not gofumpt-ed$`
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/gofumpt/internal/formatinternal"
+)
+
+// rxGitHunk matches the new line range in a hunk header from `git diff -U0`,
+// such as "@@ -10,2 +10,3 @@", where a missing count means one line.
+var rxGitHunk = regexp.MustCompile(`(?m)^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// changedRanges returns the ranges of lines in a file which changed since the
+// git revision given via -diff-base, as positions in tokFile, which must hold
+// the file's current contents.
+// The ranges are nil if the entire file is new, as it is not tracked by git.
+func changedRanges(filename string, tokFile *token.File) ([]formatinternal.Range, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	// The revision comes from the user, so it must never be parsed as an option,
+	// like "--output=file" which would overwrite a file.
+	out, err := runGit(dir, "diff", "-U0", "--no-color", "--no-ext-diff", "--end-of-options", *diffBase, "--", base)
+	if err != nil {
+		return nil, err
+	}
+	ranges := []formatinternal.Range{} // non-nil, as no changes means no ranges
+	for _, m := range rxGitHunk.FindAllSubmatch(out, -1) {
+		start, _ := strconv.Atoi(string(m[1]))
+		count := 1
+		if len(m[2]) > 0 {
+			count, _ = strconv.Atoi(string(m[2]))
+		}
+		if count == 0 {
+			// Lines were only removed after the start line,
+			// so the lines around the removal are touched.
+			count = 2
+		}
+		start = max(start, 1)
+		end := min(start+count, tokFile.LineCount()+1) // the line after the range
+		if start >= end {
+			continue
+		}
+		endPos := token.Pos(tokFile.Base() + tokFile.Size())
+		if end <= tokFile.LineCount() {
+			endPos = tokFile.LineStart(end)
+		}
+		ranges = append(ranges, formatinternal.Range{Pos: tokFile.LineStart(start), End: endPos})
+	}
+	if len(ranges) == 0 {
+		// git diff is empty for untracked files, which are entirely new.
+		if _, err := runGit(dir, "ls-files", "--error-unmatch", "--", base); err != nil {
+			return nil, nil
+		}
+	}
+	return ranges, nil
+}
+
+// runGit runs git in a directory, returning its standard output.
+func runGit(dir string, args ...string) ([]byte, error) {
+	return runGitInput(dir, nil, args...)
+}
+
+// runGitInput is like [runGit], but it also gives git a standard input.
+func runGitInput(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
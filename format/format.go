@@ -8,12 +8,14 @@ package format
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	goversion "go/version"
-	"os"
+	pathpkg "path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"slices"
@@ -25,7 +27,12 @@ import (
 
 	"golang.org/x/tools/go/ast/astutil"
 
-	"mvdan.cc/gofumpt/internal/govendor/go/format"
+	fileconfig "mvdan.cc/gofumpt/internal/config"
+	"mvdan.cc/gofumpt/internal/formatinternal"
+	"mvdan.cc/gofumpt/internal/gomod"
+	"mvdan.cc/gofumpt/internal/govendor/diff"
+	goformat "mvdan.cc/gofumpt/internal/govendor/go/format"
+	"mvdan.cc/gofumpt/internal/govendor/go/printer"
 	"mvdan.cc/gofumpt/internal/version"
 )
 
@@ -42,6 +49,11 @@ type Options struct {
 	//     go list -m -f {{.GoVersion}}
 	//
 	// with a "go" prefix, or the equivalent from `go mod edit -json`.
+	//
+	// A file with a version in its `//go:build` constraint, such as
+	// `//go:build go1.22`, is formatted with that version instead,
+	// just like go/types does with [ast.File.GoVersion]. Since go1.21,
+	// the constraint may also downgrade the version, but not below go1.21.
 	LangVersion string
 
 	// ModulePath corresponds to the Go module path which contains the source
@@ -56,14 +68,37 @@ type Options struct {
 	// is formatted as if it weren't inside a module.
 	ModulePath string
 
-	// ExtraRules enables all extra formatting rules, such as grouping function
-	// parameters with repeated types together.
+	// LocalPrefixes are import path prefixes, such as the current module path
+	// or "github.com/org/", whose imports are grouped after all other imports,
+	// like goimports' -local flag. Each prefix matches import paths which
+	// start with it, as well as the prefix without a trailing slash.
+	LocalPrefixes []string
+
+	// ExtraRules enables the extra formatting rules which were available before
+	// [Extra.SplitLongLines], such as grouping function parameters with
+	// repeated types together.
 	//
 	// Deprecated: use [Options.Extra] instead.
 	ExtraRules bool
 
 	// Extra allows enabling extra formatting rules which are disabled by default.
 	Extra Extra
+
+	// Disable allows disabling formatting rules by name,
+	// such as "short-decl" or "decl-group-many".
+	// A disabled rule is never applied, even if enabled via [Options.Extra].
+	Disable Rules
+
+	// LineLength is the maximum line length used by [Extra.SplitLongLines],
+	// where each tab counts as eight columns. When zero, a length of 100 is used.
+	LineLength int
+
+	// AllowFragments allows [Source] and [Edits] to accept a list of
+	// declarations or statements in lieu of a complete source file,
+	// just like the gofumpt tool does when reading from standard input.
+	// The leading and trailing space and the indentation of the fragment
+	// are preserved.
+	AllowFragments bool
 }
 
 // Extra is the set of extra formatting rules which are available.
@@ -83,6 +118,13 @@ type Extra struct {
 
 	// ClotheReturns clothes naked returns in functions with named results.
 	ClotheReturns bool
+
+	// SplitLongLines splits lines longer than [Options.LineLength]
+	// where a list such as call arguments can be split evenly.
+	//
+	// Since it can change a large amount of code, it is not enabled
+	// by the "true" value in [Extra.Set].
+	SplitLongLines bool
 }
 
 func (e *Extra) String() string {
@@ -93,6 +135,9 @@ func (e *Extra) String() string {
 	if e.ClotheReturns {
 		active = append(active, "clothe_returns")
 	}
+	if e.SplitLongLines {
+		active = append(active, "split_long_lines")
+	}
 	return strings.Join(active, ",")
 }
 
@@ -112,6 +157,8 @@ func (e *Extra) Set(v string) error {
 			e.GroupParams = true
 		case "clothe_returns":
 			e.ClotheReturns = true
+		case "split_long_lines":
+			e.SplitLongLines = true
 		default:
 			return fmt.Errorf("unknown rule: %q", s)
 		}
@@ -121,8 +168,113 @@ func (e *Extra) Set(v string) error {
 
 func (e *Extra) IsBoolFlag() bool { return true }
 
+// OptionsForFile returns the options which the gofumpt tool uses by default
+// to format the Go file at path, so that tools embedding gofumpt can produce
+// the same output as the gofumpt tool.
+//
+// LangVersion and ModulePath are taken from the nearest go.mod file found in
+// the file's directory or its parents. If the go.mod file lacks a go directive,
+// go1.16 is assumed. If no valid go.mod file is found, they are left empty.
+//
+// The options are then updated from the nearest gofumpt.toml file, if any,
+// which may set the keys lang, modpath, extra, disable, line-length, and local,
+// just like the gofumpt flags with the same names.
+// An error is returned if the gofumpt.toml file is invalid.
+//
+// The go.mod and gofumpt.toml files are cached per directory, so OptionsForFile
+// is cheap to call repeatedly for files in the same directories.
+// It is safe for concurrent use.
+func OptionsForFile(path string) (Options, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Options{}, err
+	}
+	dir := filepath.Dir(path)
+	var opts Options
+	if mod := gomod.Load(dir); mod != nil {
+		opts.LangVersion = mod.LangVersion()
+		opts.ModulePath = mod.Path()
+	}
+	cfg, err := fileconfig.Load(dir)
+	if err != nil {
+		return Options{}, err
+	}
+	if cfg == nil {
+		return opts, nil
+	}
+	cfgPath := filepath.Join(cfg.Dir, fileconfig.FileName)
+	if cfg.Lang != "" {
+		if !goversion.IsValid(cfg.Lang) {
+			return Options{}, fmt.Errorf("%s: invalid Go version: %q", cfgPath, cfg.Lang)
+		}
+		opts.LangVersion = cfg.Lang
+	}
+	if cfg.ModulePath != "" {
+		opts.ModulePath = cfg.ModulePath
+	}
+	if len(cfg.Extra) > 0 {
+		if err := opts.Extra.Set(strings.Join(cfg.Extra, ",")); err != nil {
+			return Options{}, fmt.Errorf("%s: extra: %w", cfgPath, err)
+		}
+	}
+	if len(cfg.Disable) > 0 {
+		if err := opts.Disable.Set(strings.Join(cfg.Disable, ",")); err != nil {
+			return Options{}, fmt.Errorf("%s: disable: %w", cfgPath, err)
+		}
+	}
+	opts.LineLength = cfg.LineLength
+	opts.LocalPrefixes = slices.Clone(cfg.Local) // the config is cached and shared
+	return opts, nil
+}
+
+// Rules is a set of formatting rules by name,
+// using the same names as reported in [Diagnostic.Rule].
+//
+// Its String and Set methods use a comma-separated list of names,
+// such as "short-decl,decl-group-many". Like in [Extra.Set],
+// Set also accepts names with underscores such as "clothe_returns",
+// as well as "true" for all rules and "false" for none.
+type Rules map[string]bool
+
+func (r *Rules) String() string {
+	var names []string
+	for name, ok := range *r {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return strings.Join(names, ",")
+}
+
+func (r *Rules) Set(v string) error {
+	*r = nil
+	if v == "" || v == "false" {
+		return nil
+	}
+	rules := make(Rules)
+	if v == "true" {
+		for name := range ruleMessages {
+			rules[name] = true
+		}
+		*r = rules
+		return nil
+	}
+	for s := range strings.SplitSeq(v, ",") {
+		name := strings.ReplaceAll(s, "_", "-")
+		if _, ok := ruleMessages[name]; !ok {
+			return fmt.Errorf("unknown rule: %q", s)
+		}
+		rules[name] = true
+	}
+	*r = rules
+	return nil
+}
+
+const parserMode = parser.ParseComments | parser.SkipObjectResolution
+
 // Source formats src in gofumpt's format, assuming that src holds a valid Go
-// source file.
+// source file, or a program fragment if [Options.AllowFragments] is set.
 func Source(src []byte, opts Options) ([]byte, error) {
 	fset := token.NewFileSet()
 
@@ -130,26 +282,182 @@ func Source(src []byte, opts Options) ([]byte, error) {
 	// to ensure that using token.NoPos+1 will panic.
 	fset.AddFile("gofumpt_base.go", 1, 10)
 
-	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution|parser.ParseComments)
+	parsed, err := formatinternal.Parse(fset, "", src, parserMode, opts.AllowFragments)
 	if err != nil {
 		return nil, err
 	}
 
-	File(fset, file, opts)
+	File(fset, parsed.File, opts)
 
+	if parsed.Fragment() {
+		return parsed.Print(fset)
+	}
 	var buf bytes.Buffer
-	if err := format.Node(&buf, fset, file); err != nil {
+	if err := goformat.Node(&buf, fset, parsed.File); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
+// A Diagnostic describes a change made by one of gofumpt's formatting rules.
+type Diagnostic struct {
+	// Rule is the stable name of the rule which made the change,
+	// such as "std-imports" or "block-empty".
+	Rule string
+
+	// Pos and End delimit the source range affected by the change,
+	// as positions in the original source before any formatting.
+	// End is equal to Pos when the change happens at a single point,
+	// such as when inserting a newline.
+	Pos, End token.Position
+
+	// Message is a short human-readable description of the change.
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Rule)
+}
+
+// Check is like [Source], but rather than returning the formatted source,
+// it reports the changes which gofumpt's rules would make to src,
+// sorted by position.
+//
+// Note that the changes made by gofmt itself, including simplification,
+// are not reported. As such, an empty result does not imply that [Source]
+// would leave src unchanged.
+func Check(src []byte, opts Options) ([]Diagnostic, error) {
+	fset := token.NewFileSet()
+	fset.AddFile("gofumpt_base.go", 1, 10)
+
+	parsed, err := formatinternal.Parse(fset, "", src, parserMode, opts.AllowFragments)
+	if err != nil {
+		return nil, err
+	}
+	f := newFumpter(fset, parsed.File, opts)
+	// Note that Lines does not return a copy,
+	// and we modify the line table in place.
+	f.origLines = slices.Clone(f.file.Lines())
+	diags := f.fumpt()
+	if !parsed.Fragment() {
+		return diags, nil
+	}
+
+	// A program fragment was wrapped with code on its first line, which
+	// we don't report changes to, such as the empty lines before a func's
+	// closing brace added after a list of statements.
+	start := parsed.Offset()
+	fragDiags := diags[:0]
+	for _, d := range diags {
+		if d.Pos.Offset < start || d.End.Offset > start+len(src) {
+			continue
+		}
+		for _, pos := range []*token.Position{&d.Pos, &d.End} {
+			if pos.Line == 1 {
+				pos.Column -= start
+			}
+			pos.Offset -= start
+		}
+		fragDiags = append(fragDiags, d)
+	}
+	return fragDiags, nil
+}
+
+// SourceRange is like [Source], but it only applies gofumpt's rules to the
+// syntax nodes which intersect the byte offset range from start to end in src.
+// Rules which act on multiple top-level declarations, such as grouping
+// contiguous declarations, only do so when all of them intersect the range.
+//
+// The entire formatted source is returned. Note that the rest of the source
+// is still formatted and simplified as gofmt would, so to only modify the
+// given range, src should already be in canonical gofmt format.
+func SourceRange(src []byte, start, end int, opts Options) ([]byte, error) {
+	if start < 0 || start > end || end > len(src) {
+		return nil, fmt.Errorf("invalid range %d-%d for source of length %d", start, end, len(src))
+	}
+	fset := token.NewFileSet()
+	fset.AddFile("gofumpt_base.go", 1, 10)
+
+	file, err := parser.ParseFile(fset, "", src, parserMode)
+	if err != nil {
+		return nil, err
+	}
+	f := newFumpter(fset, file, opts)
+	f.ranges = []posRange{{f.file.Pos(start), f.file.Pos(end)}}
+	f.fumpt()
+
+	var buf bytes.Buffer
+	if err := goformat.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// An Edit replaces the bytes from Start to End in a source file with New.
+// Start and End are byte offsets in the original source.
+type Edit struct {
+	Start, End int
+	New        string
+}
+
+// Edits is like [Source], but rather than returning the formatted source,
+// it returns a minimal list of edits which turn src into the formatted source.
+// The edits are sorted by offset and do not overlap.
+// If src is already formatted, Edits returns nil.
+func Edits(src []byte, opts Options) ([]Edit, error) {
+	res, err := Source(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	return computeEdits(src, res), nil
+}
+
+// computeEdits returns the edits from src to res as computed by [diff.Edits].
+func computeEdits(src, res []byte) []Edit {
+	var edits []Edit
+	for _, edit := range diff.Edits(src, res) {
+		edits = append(edits, Edit(edit))
+	}
+	return edits
+}
+
+// RangeEdits is like [SourceRange], but rather than returning the formatted
+// source, it returns the edits which intersect the byte offset range from start
+// to end in src, like [Edits] does. As such, unlike SourceRange, the source
+// outside the range is left alone even if it is not in canonical gofmt format.
+func RangeEdits(src []byte, start, end int, opts Options) ([]Edit, error) {
+	res, err := SourceRange(src, start, end, opts)
+	if err != nil {
+		return nil, err
+	}
+	var edits []Edit
+	for _, edit := range computeEdits(src, res) {
+		if edit.End >= start && edit.Start <= end {
+			edits = append(edits, edit)
+		}
+	}
+	return edits, nil
+}
+
 // File modifies a file and fset in place to follow gofumpt's format. The
 // changes might include manipulating adding or removing newlines in fset,
 // modifying the position of nodes, or modifying literal values.
 func File(fset *token.FileSet, file *ast.File, opts Options) {
-	simplify(file)
+	newFumpter(fset, file, opts).fumpt()
+}
+
+func init() {
+	formatinternal.FileRanges = func(fset *token.FileSet, file *ast.File, ranges []formatinternal.Range, opts any) {
+		f := newFumpter(fset, file, opts.(Options))
+		f.ranges = make([]posRange, len(ranges))
+		for i, r := range ranges {
+			f.ranges[i] = posRange{r.Pos, r.End}
+		}
+		f.fumpt()
+	}
+}
 
+func newFumpter(fset *token.FileSet, file *ast.File, opts Options) *fumpter {
 	if opts.ExtraRules {
 		opts.Extra.Set("true") // enable all the extra rules
 	}
@@ -163,7 +471,8 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 		}
 		opts.LangVersion = lang
 	}
-	f := &fumpter{
+	opts.LangVersion = formatinternal.FileLangVersion(file, opts.LangVersion)
+	return &fumpter{
 		file:    fset.File(file.Pos()),
 		fset:    fset,
 		astFile: file,
@@ -171,9 +480,38 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 
 		minSplitFactor: 0.4,
 	}
+}
+
+// fumpt applies gofumpt's rules to the file,
+// returning the diagnostics collected along the way, if any.
+func (f *fumpter) fumpt() []Diagnostic {
+	simplify(f.astFile)
+
 	var topFuncType *ast.FuncType
 	pre := func(c *astutil.Cursor) bool {
-		f.applyPre(c)
+		if !f.inRanges(c.Node()) {
+			// Outside the ranges we were asked to format.
+			return false
+		}
+		if decl, ok := c.Node().(ast.Decl); ok {
+			if _, ok := c.Parent().(*ast.File); ok {
+				f.topDecl = decl
+				f.measured = nil
+				f.staleRanges = f.staleRanges[:0]
+				f.splitRanges = f.splitRanges[:0]
+				clear(f.splitIndents)
+				clear(f.listSplits)
+			}
+		}
+		f.pushUnit(c)
+		switch overlaps, within := f.offRegion(c.Node()); {
+		case within:
+			// Leave the entire node as is, including its children.
+			f.popUnit()
+			return false
+		case !overlaps:
+			f.applyPre(c)
+		}
 		switch node := c.Node().(type) {
 		case *ast.FuncDecl:
 			topFuncType = node.Type
@@ -191,7 +529,7 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 			// This avoids func lines which are a bit too short,
 			// and allows func lines which are a bit longer.
 			//
-			// We don't just increase longLineLimit,
+			// We don't just increase the line length,
 			// as we still want splits at around the same place.
 			if ft.Params == node {
 				f.minSplitFactor = 0.6
@@ -205,15 +543,16 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 			if ft.Results == node {
 				f.minSplitFactor = 1000
 			}
-		case *ast.BlockStmt:
-			f.blockLevel++
 		}
 		return true
 	}
 	post := func(c *astutil.Cursor) bool {
-		f.applyPost(c)
+		if overlaps, _ := f.offRegion(c.Node()); !overlaps {
+			f.applyPost(c)
+		}
+		f.popUnit()
 
-		// Reset minSplitFactor and blockLevel.
+		// Reset minSplitFactor.
 		switch node := c.Node().(type) {
 		case *ast.FuncDecl, *ast.FuncLit:
 			f.parentFuncTypes = f.parentFuncTypes[:len(f.parentFuncTypes)-1]
@@ -221,21 +560,45 @@ func File(fset *token.FileSet, file *ast.File, opts Options) {
 			if node == topFuncType {
 				f.minSplitFactor = 0.4
 			}
-		case *ast.BlockStmt:
-			f.blockLevel--
 		}
 		return true
 	}
-	astutil.Apply(file, pre, post)
+	astutil.Apply(f.astFile, pre, post)
+
+	return f.sortedDiagnostics()
+}
+
+// sortedDiagnostics returns the collected diagnostics sorted by position.
+// A rule may report a change within a range it already reported,
+// such as when it both reorders and separates imports in a group,
+// so those redundant diagnostics are dropped.
+func (f *fumpter) sortedDiagnostics() []Diagnostic {
+	slices.SortStableFunc(f.diagnostics, func(a, b Diagnostic) int {
+		if c := cmp.Compare(a.Pos.Offset, b.Pos.Offset); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.End.Offset, a.End.Offset)
+	})
+	lastByRule := make(map[string]Diagnostic)
+	diags := f.diagnostics[:0]
+	for _, d := range f.diagnostics {
+		if last, ok := lastByRule[d.Rule]; ok && d.End.Offset <= last.End.Offset {
+			continue // within the last diagnostic for this rule
+		}
+		lastByRule[d.Rule] = d
+		diags = append(diags, d)
+	}
+	return diags
 }
 
 // Multiline nodes which could easily fit on a single line under this many bytes
 // may be collapsed onto a single line.
 const shortLineLimit = 60
 
-// Single-line nodes which take over this many bytes, and could easily be split
+// Single-line nodes which take over this many columns, and could easily be split
 // into two lines of at least its minSplitFactor factor, may be split.
-const longLineLimit = 100
+// Used when [Options.LineLength] is zero.
+const defaultLineLength = 100
 
 var rxOctalInteger = regexp.MustCompile(`\A0[0-7_]+\z`)
 
@@ -247,16 +610,180 @@ type fumpter struct {
 
 	astFile *ast.File
 
-	// blockLevel is the number of indentation blocks we're currently under.
-	// It is used to approximate the levels of indentation a line will end
-	// up with.
-	blockLevel int
+	// topDecl is the top-level declaration we're currently under,
+	// printed once to measure how its nodes are rendered.
+	topDecl  ast.Decl
+	measured *printer.Measurement
+
+	// staleRanges holds the sorted source offset ranges which have changed
+	// since topDecl was printed, so that their widths must be measured again.
+	// Splitting long lines only adds line breaks, so it adds to splitRanges
+	// instead, where only the indentation of lines may have changed.
+	// See [fumpter.split].
+	staleRanges []sourceRange
+	splitRanges []sourceRange
+
+	// units is the stack of nodes we're currently under which can be
+	// printed on their own by measure, and unitsPushed records whether
+	// each node being visited pushed to units, so that it can be popped.
+	units       []measureUnit
+	unitsPushed []bool
+
+	// numChanges counts the changes made so far, so that the last unit
+	// printed again by measure can be reused until the next change.
+	numChanges   int
+	lastUnit     ast.Node
+	lastMeasured *printer.Measurement
+	lastChanges  int
+
+	// splitIndents holds the indentation of each line started by a split,
+	// keyed by its start offset, or -1 if not known yet.
+	// listSplits holds the start offset of the last line started by a split
+	// in each list, as all lines started by splits in a list share the same
+	// indentation.
+	splitIndents map[int]int
+	listSplits   map[ast.Node]int
 
 	minSplitFactor float64
 
 	// parentFuncTypes is a stack of parent function types,
 	// used to determine return type information when clothing naked returns.
 	parentFuncTypes []*ast.FuncType
+
+	// rule is the name of the rule currently being applied,
+	// used to attribute changes to rules in diagnostics.
+	rule string
+
+	// origLines is the line table of file before any changes,
+	// so that diagnostics can refer to the original source.
+	// It is nil unless we are collecting diagnostics.
+	origLines   []int
+	diagnostics []Diagnostic
+
+	// ranges are the source ranges where gofumpt's rules may apply.
+	// When nil, the rules apply to the entire file.
+	ranges []posRange
+
+	// offRegions are the sorted source ranges where gofumpt's rules
+	// are turned off via comments like //gofumpt:off.
+	offRegions []posRange
+
+	// mergedImports are the import specs which were moved into the first
+	// import declaration from a later one by the merge-imports rule.
+	mergedImports map[*ast.ImportSpec]bool
+}
+
+type posRange struct {
+	pos, end token.Pos
+}
+
+func (r posRange) overlaps(node ast.Node) bool {
+	return node.Pos() < r.end && r.pos < node.End()
+}
+
+// Names for gofumpt's formatting rules, as reported in [Diagnostic.Rule]
+// and accepted by [Options.Disable].
+const (
+	ruleAssignmentNewlines = "assignment-newlines"
+	ruleBlockEmpty         = "block-empty"
+	ruleBlockSingle        = "block-single"
+	ruleCallMultiline      = "call-multiline"
+	ruleClotheReturns      = "clothe-returns"
+	ruleCommentSpaced      = "comment-spaced"
+	ruleCompositeLeading   = "composite-leading-lines"
+	ruleCompositeMultiline = "composite-multiline"
+	ruleDeclGroupMany      = "decl-group-many"
+	ruleDeclGroupSingle    = "decl-group-single"
+	ruleDeclsSeparated     = "decls-separated"
+	ruleDuplicateImports   = "duplicate-imports"
+	ruleFieldList          = "field-list"
+	ruleFuncBody           = "func-body"
+	ruleFuncSignature      = "func-signature"
+	ruleGroupParams        = "group-params"
+	ruleInterface          = "interface"
+	ruleLocalImports       = "local-imports"
+	ruleMergeImports       = "merge-imports"
+	ruleNewlineErrcheck    = "newline-errcheck"
+	ruleOctalLiterals      = "octal-literals"
+	ruleParenRemove        = "paren-remove"
+	ruleRedundantAlias     = "redundant-alias"
+	ruleShortCase          = "short-case"
+	ruleShortDecl          = "short-decl"
+	ruleSplitLongLines     = "split-long-lines"
+	ruleStdImports         = "std-imports"
+)
+
+// ruleMessages holds the diagnostic message for each rule.
+var ruleMessages = map[string]string{
+	ruleAssignmentNewlines: "newline after assignment operator removed",
+	ruleBlockEmpty:         "empty block joined onto one line",
+	ruleBlockSingle:        "empty lines around lone statement removed",
+	ruleCallMultiline:      "closing parenthesis of multi-line call moved to its own line",
+	ruleClotheReturns:      "naked return clothed",
+	ruleCommentSpaced:      "space added after comment slashes",
+	ruleCompositeLeading:   "empty lines around composite literal elements removed",
+	ruleCompositeMultiline: "newlines in composite literal made consistent",
+	ruleDeclGroupMany:      "contiguous declarations grouped",
+	ruleDeclGroupSingle:    "parentheses around single declaration removed",
+	ruleDeclsSeparated:     "empty line added between multi-line declarations",
+	ruleFieldList:          "empty lines in field list removed",
+	ruleFuncBody:           "empty lines around function body removed",
+	ruleFuncSignature:      "closing parenthesis of multi-line signature moved to its own line",
+	ruleGroupParams:        "adjacent parameters with the same type grouped",
+	ruleInterface:          "empty lines before first interface method removed",
+	ruleNewlineErrcheck:    "empty lines before error check removed",
+	ruleOctalLiterals:      "octal literal prefixed",
+	ruleParenRemove:        "useless parentheses removed",
+	ruleShortCase:          "short case clause joined onto one line",
+	ruleShortDecl:          "var declaration replaced with short assignment",
+	ruleSplitLongLines:     "long line split",
+	ruleStdImports:         "std imports grouped",
+	ruleLocalImports:       "local imports grouped last",
+	ruleMergeImports:       "import declarations merged",
+	ruleDuplicateImports:   "duplicate import removed",
+	ruleRedundantAlias:     "redundant import alias removed",
+}
+
+// useRule sets the rule currently being applied, so that changes can be
+// attributed to it, and reports whether the rule is enabled.
+func (f *fumpter) useRule(name string) bool {
+	f.rule = name
+	return !f.Disable[name]
+}
+
+// changed records a diagnostic for a change made by the current rule
+// to the source between pos and end.
+func (f *fumpter) changed(pos, end token.Pos) {
+	f.numChanges++
+	if f.measured != nil && f.rule != ruleSplitLongLines && pos < f.topDecl.End() {
+		// The change may affect how the rest of its statement is printed.
+		stale := sourceRange{f.Offset(pos), f.Offset(f.enclosingUnitEnd(pos))}
+		f.staleRanges = addRange(f.staleRanges, stale)
+	}
+	if f.origLines == nil || f.rule == "" {
+		return
+	}
+	f.diagnostics = append(f.diagnostics, Diagnostic{
+		Rule:    f.rule,
+		Pos:     f.origPosition(pos),
+		End:     f.origPosition(end),
+		Message: ruleMessages[f.rule],
+	})
+}
+
+// origPosition is like Position, but uses the original line table.
+func (f *fumpter) origPosition(p token.Pos) token.Position {
+	offset := f.Offset(p)
+	i, exists := slices.BinarySearch(f.origLines, offset)
+	if !exists {
+		i-- // offset is within line i, which starts before it
+	}
+	return token.Position{
+		Filename: f.file.Name(),
+		Offset:   offset,
+		Line:     i + 1,
+		Column:   offset - f.origLines[i] + 1,
+	}
 }
 
 func (f *fumpter) commentsBetween(p1, p2 token.Pos) []*ast.CommentGroup {
@@ -304,11 +831,15 @@ func (f *fumpter) addNewline(at token.Pos) {
 	if !f.file.SetLines(lines) {
 		panic(fmt.Sprintf("could not set lines to %v", lines))
 	}
+	f.changed(at, at)
 }
 
 // removeLines removes all newlines between two positions, so that they end
 // up on the same line.
 func (f *fumpter) removeLines(fromLine, toLine int) {
+	if fromLine < toLine {
+		f.changed(f.lineEnd(fromLine), f.file.LineStart(toLine))
+	}
 	for fromLine < toLine {
 		f.file.MergeLine(fromLine)
 		toLine--
@@ -328,6 +859,7 @@ func (f *fumpter) removeParens(node *ast.GenDecl) {
 		!node.Lparen.IsValid() || node.Doc != nil {
 		return
 	}
+	f.changed(node.Pos(), node.End())
 	specPos := node.Specs[0].Pos()
 	specEnd := node.Specs[0].End()
 
@@ -364,29 +896,244 @@ func (f *fumpter) Offset(p token.Pos) int {
 	return f.file.Offset(p)
 }
 
-type byteCounter int
+// measure reports how the source range from pos to end is currently rendered
+// within its top-level declaration, including its real indentation.
+// It reports false if the range was not found in the printed declaration.
+//
+// The top-level declaration is only printed once. Once a range in it has
+// changed, only the enclosing unit is printed again, which is node if it is
+// not nil, or otherwise the innermost unit we're under which starts its own
+// line, as tracked by pushUnit. The unit is only printed again once per change.
+// Its starting position is taken from the printed declaration,
+// as changes within a unit do not move its start.
+func (f *fumpter) measure(node ast.Node, pos, end token.Pos) (printer.Span, bool) {
+	if f.topDecl == nil {
+		return printer.Span{}, false
+	}
+	if f.measured == nil {
+		node := &printer.CommentedNode{Node: f.topDecl, Comments: f.astFile.Comments}
+		m, err := formatinternal.Config.Measure(f.fset, node)
+		if err != nil {
+			return printer.Span{}, false
+		}
+		f.measured = m
+	}
+	stale := inRanges(f.staleRanges, f.Offset(pos))
+	if !stale && !inRanges(f.splitRanges, f.Offset(pos)) {
+		return f.measured.Span(f.Offset(pos), f.Offset(end))
+	}
+	var start printer.Span
+	if node != nil {
+		var ok bool
+		if start, ok = f.measured.Span(f.Offset(node.Pos()), f.Offset(node.Pos())+1); !ok {
+			return printer.Span{}, false
+		}
+	} else {
+		node, start = f.innermostUnit(pos)
+		if node == nil {
+			return printer.Span{}, false
+		}
+	}
+	if !stale && int(node.End()-node.Pos()) > maxMeasureUnitSize {
+		// Printing a large unit again after every split would take
+		// quadratic time, such as with long tables on a single line.
+		if span, ok := f.measureSplitLine(pos, end); ok {
+			return span, true
+		}
+	}
+	m := f.lastMeasured
+	if node != f.lastUnit || f.lastChanges != f.numChanges {
+		var err error
+		if m, err = formatinternal.Config.Measure(f.fset, node); err != nil {
+			return printer.Span{}, false
+		}
+		f.lastUnit, f.lastMeasured, f.lastChanges = node, m, f.numChanges
+	}
+	span, ok := m.Span(f.Offset(pos), f.Offset(end))
+	if !ok {
+		return printer.Span{}, false
+	}
+	if span.Line == 1 {
+		// On the line where the unit starts, which may not be at its indentation.
+		span.Column += start.Column - 1
+		span.LineWidth += start.Column - 1
+		span.Indent = start.Indent
+	} else {
+		// On a continuation line, which is indented relative to the unit.
+		span.Column += start.Indent
+		span.LineWidth += start.Indent
+		span.Indent += start.Indent
+	}
+	span.Line += start.Line - 1
+	span.EndLine += start.Line - 1
+	if lineStart := f.Offset(f.file.LineStart(f.Line(pos))); f.splitIndents[lineStart] < 0 {
+		f.splitIndents[lineStart] = span.Indent
+	}
+	return span, true
+}
+
+// A sourceRange is a range of source offsets.
+type sourceRange struct {
+	from, to int
+}
+
+// addRange adds r to the sorted ranges, merging any which overlap with it.
+func addRange(ranges []sourceRange, r sourceRange) []sourceRange {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].to >= r.from })
+	j := i
+	for ; j < len(ranges) && ranges[j].from <= r.to; j++ {
+		r.from = min(r.from, ranges[j].from)
+		r.to = max(r.to, ranges[j].to)
+	}
+	return slices.Replace(ranges, i, j, r)
+}
+
+// inRanges reports whether the source offset is in any of the sorted ranges.
+func inRanges(ranges []sourceRange, offset int) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].to > offset })
+	return i < len(ranges) && ranges[i].from <= offset
+}
+
+// enclosingUnitEnd returns the end of the line where the innermost unit
+// containing pos ends, as changes at pos do not affect how anything after it
+// is printed.
+func (f *fumpter) enclosingUnitEnd(pos token.Pos) token.Pos {
+	unit, _ := f.innermostUnit(pos)
+	if unit == nil {
+		return f.topDecl.End()
+	}
+	return max(unit.End(), f.lineEnd(f.Line(unit.End())))
+}
+
+// split adds a line break at the start of a list element to split a long line,
+// where list is the node holding the list.
+//
+// The line break moves the rest of its line, and can only change the
+// indentation of the lines which follow it within the list,
+// as the printer indents the rest of a list once it spans multiple lines,
+// and unindents at its end.
+func (f *fumpter) split(at token.Pos, list ast.Node) {
+	if _, exists := slices.BinarySearch(f.file.Lines(), f.Offset(at)); exists {
+		return
+	}
+	f.addNewline(at)
 
-func (b *byteCounter) Write(p []byte) (n int, err error) {
-	*b += byteCounter(len(p))
-	return len(p), nil
+	offset := f.Offset(at)
+	end := max(list.End(), f.lineEnd(f.Line(at)))
+	f.splitRanges = addRange(f.splitRanges, sourceRange{offset, f.Offset(end)})
+	if f.splitIndents == nil {
+		f.splitIndents = make(map[int]int)
+		f.listSplits = make(map[ast.Node]int)
+	}
+	f.splitIndents[offset] = -1
+	if prev, ok := f.listSplits[list]; ok {
+		f.splitIndents[offset] = f.splitIndents[prev]
+	}
+	f.listSplits[list] = offset
 }
 
-func (f *fumpter) printLength(node ast.Node) int {
-	var count byteCounter
-	if err := format.Node(&count, f.fset, node); err != nil {
-		panic(fmt.Sprintf("unexpected print error: %v", err))
+// measureSplitLine is like measure for a range on a line started by a split,
+// when the indentation of the line is already known.
+// As splits only add line breaks, the rest of the line can be measured
+// from the printed declaration, where the line is still part of a longer line.
+func (f *fumpter) measureSplitLine(pos, end token.Pos) (printer.Span, bool) {
+	line := f.Line(pos)
+	lineStart := f.Offset(f.file.LineStart(line))
+	indent, ok := f.splitIndents[lineStart]
+	if !ok || indent < 0 {
+		return printer.Span{}, false
+	}
+	lineEnd := f.file.Size()
+	if line < f.file.LineCount() {
+		lineEnd = f.Offset(f.file.LineStart(line + 1))
+	}
+	whole, ok := f.measured.Span(lineStart, lineEnd)
+	if !ok || whole.EndLine != whole.Line {
+		return printer.Span{}, false
+	}
+	span, ok := f.measured.Span(f.Offset(pos), f.Offset(end))
+	if !ok || span.Line != whole.Line {
+		return printer.Span{}, false
+	}
+	span.Column += indent - (whole.Column - 1)
+	span.Indent = indent
+	span.LineWidth = indent + whole.Width
+	return span, true
+}
+
+// maxMeasureUnitSize is the size in bytes of the largest unit which measure
+// prints again after splitting long lines in it.
+const maxMeasureUnitSize = 4 << 10
+
+// A measureUnit is a node which can be printed on its own by measure.
+// Some nodes, like the elements in a composite literal, are only used
+// if they start their own line, so that their start does not move when
+// other nodes on the same line change.
+type measureUnit struct {
+	node      ast.Node
+	lineStart bool // only used if it starts its own line
+}
+
+// innermostUnit returns the innermost unit we're under containing pos which
+// can be printed on its own by measure, and where it starts in the printed
+// declaration.
+func (f *fumpter) innermostUnit(pos token.Pos) (ast.Node, printer.Span) {
+	for _, unit := range slices.Backward(f.units) {
+		if pos < unit.node.Pos() || pos >= unit.node.End() {
+			continue
+		}
+		start, ok := f.measured.Span(f.Offset(unit.node.Pos()), f.Offset(unit.node.Pos())+1)
+		if !ok {
+			continue
+		}
+		if unit.lineStart && start.Column != start.Indent+1 {
+			continue
+		}
+		return unit.node, start
 	}
+	start, ok := f.measured.Span(f.Offset(f.topDecl.Pos()), f.Offset(f.topDecl.Pos())+1)
+	if !ok {
+		return nil, printer.Span{}
+	}
+	return f.topDecl, start
+}
 
-	// Add the space taken by an inline comment.
-	if c := f.inlineComment(node.End()); c != nil {
-		fmt.Fprintf(&count, " %s", c.Text)
+// pushUnit pushes the node at the cursor to the stack of units to be printed
+// on their own by [fumpter.measure]: a statement in a statement list, a spec,
+// a func declaration without its body, or an element or argument in a list.
+func (f *fumpter) pushUnit(c *astutil.Cursor) {
+	var unit measureUnit
+	switch node := c.Node().(type) {
+	case *ast.BlockStmt:
+	case ast.Stmt:
+		switch c.Parent().(type) {
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+			unit.node = node
+		}
+	case ast.Spec:
+		unit.node = node
+	case *ast.FuncDecl:
+		unit.node = &ast.FuncDecl{Recv: node.Recv, Name: node.Name, Type: node.Type}
+	case ast.Expr:
+		switch c.Parent().(type) {
+		case *ast.CompositeLit, *ast.CallExpr:
+			if c.Index() >= 0 {
+				unit = measureUnit{node: node, lineStart: true}
+			}
+		}
+	}
+	if unit.node != nil {
+		f.units = append(f.units, unit)
 	}
+	f.unitsPushed = append(f.unitsPushed, unit.node != nil)
+}
 
-	// Add an approximation of the indentation level. We can't know the
-	// number of tabs go/printer will add ahead of time. Trying to print the
-	// entire top-level declaration would tell us that, but then it's near
-	// impossible to reliably find our node again.
-	return int(count) + (f.blockLevel * 8)
+// popUnit undoes the last call to [fumpter.pushUnit].
+func (f *fumpter) popUnit() {
+	if f.unitsPushed[len(f.unitsPushed)-1] {
+		f.units = f.units[:len(f.units)-1]
+	}
+	f.unitsPushed = f.unitsPushed[:len(f.unitsPushed)-1]
 }
 
 func (f *fumpter) lineEnd(line int) token.Pos {
@@ -485,113 +1232,51 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 
 	switch node := c.Node().(type) {
 	case *ast.File:
+		// Find where gofumpt is turned off first,
+		// as the rules below and on all other nodes must skip those regions.
+		f.findOffRegions(node)
+
 		// Unwrap single-spec var groups before the joining below,
 		// so an adjacent var line and var group merge in one pass.
-		for _, decl := range node.Decls {
-			if decl, ok := decl.(*ast.GenDecl); ok {
-				f.removeParens(decl)
+		if f.useRule(ruleDeclGroupSingle) {
+			for _, decl := range node.Decls {
+				if decl, ok := decl.(*ast.GenDecl); ok && f.inScope(decl) {
+					f.removeParens(decl)
+				}
 			}
 		}
 
-		// Join contiguous lone var/const/import lines.
-		// Abort if there are empty lines in between,
-		// including a leading comment if it's a directive.
-		newDecls := make([]ast.Decl, 0, len(node.Decls))
-		for i := 0; i < len(node.Decls); {
-			newDecls = append(newDecls, node.Decls[i])
-			start, ok := node.Decls[i].(*ast.GenDecl)
-			if !ok || isCgoImport(start) || containsAnyDirective(start.Doc) {
-				i++
-				continue
-			}
-			lastPos := start.Pos()
-			merged := false
-		contLoop:
-			for i++; i < len(node.Decls); {
-				cont, ok := node.Decls[i].(*ast.GenDecl)
-				if !ok || cont.Tok != start.Tok || cont.Lparen != token.NoPos || isCgoImport(cont) {
-					break
-				}
-				// Are there things between these two declarations? e.g. empty lines, comments, directives
-				// If so, break the chain on empty lines and directives, continue below for comments.
-				if f.Line(lastPos) < f.Line(cont.Pos())-1 {
-					// break on empty line
-					if cont.Doc == nil {
-						break
-					}
-					// break on directive
-					for i, comment := range cont.Doc.List {
-						if f.Line(comment.Slash) != f.Line(lastPos)+1+i || rxCommentDirective.MatchString(strings.TrimPrefix(comment.Text, "//")) {
-							break contLoop
-						}
-					}
-					// continue below for comments
-				}
+		// Merge import blocks before the joining of lone imports below,
+		// which only joins contiguous lone imports.
+		if f.useRule(ruleMergeImports) {
+			f.mergeImportDecls(node)
+		}
 
-				start.Specs = append(start.Specs, cont.Specs...)
-				merged = true
-				end := cont.End()
-				if c := f.inlineComment(cont.End()); c != nil {
-					// don't move an inline comment outside
-					end = c.End()
-				}
-				// Point Rparen at the last content character, like a real
-				// ')', so start.End() stays on the content's final line and
-				// the empty-line separator below is idempotent in one pass.
-				start.Rparen = end - 1
-				lastPos = cont.Pos()
-				i++
-			}
-			// Re-sort imports in the new group so the output is idempotent.
-			// Set Lparen so ast.SortImports doesn't skip the merged decl.
-			if merged && start.Tok == token.IMPORT {
-				start.Lparen = start.TokPos + token.Pos(len("import"))
-				ast.SortImports(f.fset, f.astFile)
-			}
+		// Remove redundant aliases first, as they may reveal duplicates.
+		if f.useRule(ruleRedundantAlias) {
+			f.removeRedundantAliases(node)
+		}
+		if f.useRule(ruleDuplicateImports) {
+			f.removeDuplicateImports(node)
+		}
+
+		if f.useRule(ruleDeclGroupMany) {
+			f.joinContiguousDecls(node)
 		}
-		node.Decls = newDecls
 
-		// Multiline top-level declarations should be separated by an
-		// empty line.
 		// Do this after the joining of lone declarations above,
 		// as joining single-line declarations makes then multi-line.
-		var lastMulti bool
-		var lastEnd token.Pos
-		for _, decl := range node.Decls {
-			pos := decl.Pos()
-			// Trailing inline comments on lastEnd's line belong to the
-			// previous decl and extend its effective end.
-			effectiveEnd := lastEnd
-			lastEndLine := f.Line(lastEnd)
-			for _, cg := range f.commentsBetween(lastEnd, pos) {
-				if f.Line(cg.Pos()) != lastEndLine {
-					pos = cg.Pos()
-					break
-				}
-				effectiveEnd = cg.End()
-			}
-
-			// Note that we want End-1, as End is the character after the node.
-			multi := f.Line(pos) < f.Line(decl.End()-1)
-			// A func declaration which fits on a single source line may
-			// still be printed across multiple lines: go/printer's funcBody
-			// breaks the body onto its own lines once header+body exceeds
-			// 100 bytes. Approximate that with the source byte length.
-			if fn, _ := decl.(*ast.FuncDecl); fn != nil && !multi && fn.Body != nil &&
-				f.Offset(fn.End())-f.Offset(fn.Pos()) > 100 {
-				multi = true
-			}
-			if multi && lastMulti && f.Line(effectiveEnd)+1 == f.Line(pos) {
-				f.addNewline(effectiveEnd)
-			}
-
-			lastMulti = multi
-			lastEnd = decl.End()
+		if f.useRule(ruleDeclsSeparated) {
+			f.separateMultilineDecls(node)
 		}
 
 		// Comments aren't nodes, so they're not walked by default.
+		spaceComments := f.useRule(ruleCommentSpaced)
 	groupLoop:
 		for _, group := range node.Comments {
+			if !f.inScope(group) {
+				continue groupLoop
+			}
 			for _, comment := range group.List {
 				// Leave shebang lines like `//usr/bin/env go run` alone.
 				if f.Line(comment.Slash) == 1 && rxShebangComment.MatchString(comment.Text) {
@@ -609,6 +1294,15 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 					if s := f.Extra.String(); s != "" {
 						slc = append(slc, "-extra="+s)
 					}
+					if s := f.Disable.String(); s != "" {
+						slc = append(slc, "-disable="+s)
+					}
+					if len(f.LocalPrefixes) > 0 {
+						slc = append(slc, "-local="+strings.Join(f.LocalPrefixes, ","))
+					}
+					if f.LineLength > 0 {
+						slc = append(slc, "-line-length="+strconv.Itoa(f.LineLength))
+					}
 					comment.Text = strings.Join(slc, " ")
 				}
 				body := strings.TrimPrefix(comment.Text, "//")
@@ -626,7 +1320,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 					continue groupLoop
 				}
 			}
-			if commentGroupLooksLikeCode(group) {
+			if !spaceComments || commentGroupLooksLikeCode(group) {
 				continue groupLoop
 			}
 			// If none of the comment group's lines look like a
@@ -635,6 +1329,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 				body := strings.TrimPrefix(comment.Text, "//")
 				r, _ := utf8.DecodeRuneInString(body)
 				if !unicode.IsSpace(r) {
+					f.changed(comment.Pos(), comment.End())
 					comment.Text = "// " + body
 				}
 			}
@@ -649,6 +1344,10 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		if spec.Type != nil {
 			break // e.g. var name Type
 		}
+		if !f.useRule(ruleShortDecl) {
+			break
+		}
+		f.changed(node.Pos(), node.End())
 		tok := token.ASSIGN
 		names := make([]ast.Expr, len(spec.Names))
 		for i, name := range spec.Names {
@@ -664,16 +1363,22 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		})
 
 	case *ast.GenDecl:
-		if node.Tok == token.IMPORT && node.Lparen.IsValid() {
+		if node.Tok == token.IMPORT && node.Lparen.IsValid() && f.useRule(ruleStdImports) {
 			f.joinStdImports(node)
 		}
+		if node.Tok == token.IMPORT && node.Lparen.IsValid() &&
+			len(f.LocalPrefixes) > 0 && f.useRule(ruleLocalImports) {
+			f.joinLocalImports(node)
+		}
 
 		// Single var declarations shouldn't use parentheses, unless
 		// there's a comment on the grouped declaration.
-		f.removeParens(node)
+		if f.useRule(ruleDeclGroupSingle) {
+			f.removeParens(node)
+		}
 
 	case *ast.InterfaceType:
-		if len(node.Methods.List) > 0 {
+		if len(node.Methods.List) > 0 && f.useRule(ruleInterface) {
 			method := node.Methods.List[0]
 			removeToPos := method.Pos()
 			if comments := f.commentsBetween(node.Interface, method.Pos()); len(comments) > 0 {
@@ -688,7 +1393,9 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		f.stmts(node.List)
 		comments := f.commentsBetween(node.Lbrace, node.Rbrace)
 		if len(node.List) == 0 && len(comments) == 0 {
-			f.removeLinesBetween(node.Lbrace, node.Rbrace)
+			if f.useRule(ruleBlockEmpty) {
+				f.removeLinesBetween(node.Lbrace, node.Rbrace)
+			}
 			break
 		}
 
@@ -710,6 +1417,10 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			// it's a func body.
 			break
 		}
+		bodyRule := ruleBlockSingle
+		if sign != nil {
+			bodyRule = ruleFuncBody
+		}
 		var bodyPos, bodyEnd token.Pos
 
 		if len(node.List) > 0 {
@@ -725,14 +1436,17 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			}
 		}
 
-		f.removeLinesBetween(bodyEnd, node.Rbrace)
+		trimBody := f.useRule(bodyRule)
+		if trimBody {
+			f.removeLinesBetween(bodyEnd, node.Rbrace)
+		}
 
 		if cond != nil && f.Line(cond.Pos()) != f.Line(cond.End()) {
 			// The body is preceded by a multi-line condition, so an
 			// empty line can help readability.
 			return
 		}
-		if sign != nil {
+		if sign != nil && f.useRule(ruleFuncSignature) {
 			endLine := f.Line(sign.End())
 
 			if f.Line(sign.Pos()) != endLine {
@@ -780,7 +1494,10 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			}
 		}
 
-		f.removeLinesBetween(node.Lbrace, bodyPos)
+		if trimBody {
+			f.rule = bodyRule
+			f.removeLinesBetween(node.Lbrace, bodyPos)
+		}
 
 	case *ast.CaseClause:
 		f.stmts(node.Body)
@@ -794,13 +1511,32 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			// don't move comments
 			break
 		}
-		// check the length excluding the body
+		if !f.useRule(ruleShortCase) {
+			break
+		}
+		// check the length excluding the body, as if it were a single line
 		nodeWithoutBody := &ast.CaseClause{
 			Case:  node.Case,
 			List:  node.List,
 			Colon: node.Colon,
 		}
-		if f.printLength(nodeWithoutBody) > shortLineLimit {
+		var buf bytes.Buffer
+		if err := goformat.Node(&buf, f.fset, nodeWithoutBody); err != nil {
+			break
+		}
+		span, ok := f.measure(nodeWithoutBody, node.Case, node.Colon+1)
+		if !ok {
+			break
+		}
+		// Count the clause as printed on its own, including its line breaks,
+		// plus its real indentation and one more level. In plain code this
+		// matches the old estimate from the number of nested blocks,
+		// so only clauses which are indented further can be left alone.
+		length := buf.Len() + span.Indent + 8
+		if c := f.inlineComment(node.Colon + 1); c != nil {
+			length += 1 + len(c.Text)
+		}
+		if length > shortLineLimit {
 			// too long to collapse
 			break
 		}
@@ -813,14 +1549,16 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		numFields := node.NumFields()
 		comments := f.commentsBetween(node.Pos(), node.End())
 
-		if numFields == 0 && len(comments) == 0 {
+		switch {
+		case !f.useRule(ruleFieldList):
+		case numFields == 0 && len(comments) == 0:
 			// Empty field lists should not contain a newline.
 			// Do not join the two lines if the first has an inline
 			// comment, as that can result in broken formatting.
 			openLine := f.Line(node.Pos())
 			closeLine := f.Line(node.End())
 			f.removeLines(openLine, closeLine)
-		} else {
+		default:
 			// Remove lines before first comment/field and lines after last
 			// comment/field
 			var bodyPos, bodyEnd token.Pos
@@ -840,7 +1578,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			f.removeLinesBetween(bodyEnd, node.End())
 		}
 
-		if !f.Extra.GroupParams {
+		if !f.Extra.GroupParams || !f.useRule(ruleGroupParams) {
 			break
 		}
 		switch c.Parent().(type) {
@@ -852,17 +1590,25 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		}
 
 	case *ast.ParenExpr:
+		if !f.useRule(ruleParenRemove) {
+			break
+		}
 		// Unwrap any chain of redundant inner parens first,
 		// since astutil.Apply does not walk replacement nodes.
-		node.X = ast.Unparen(node.X)
+		if inner, ok := node.X.(*ast.ParenExpr); ok {
+			f.changed(inner.Pos(), inner.End())
+			node.X = ast.Unparen(inner)
+		}
 		if f.canRemoveParens(node) {
+			f.changed(node.Pos(), node.End())
 			c.Replace(node.X)
 		}
 
 	case *ast.BasicLit:
 		// Octal number literals were introduced in Go 1.13.
 		if goversion.Compare(f.LangVersion, "go1.13") >= 0 {
-			if node.Kind == token.INT && rxOctalInteger.MatchString(node.Value) {
+			if node.Kind == token.INT && rxOctalInteger.MatchString(node.Value) && f.useRule(ruleOctalLiterals) {
+				f.changed(node.Pos(), node.End())
 				node.Value = "0o" + node.Value[1:]
 				c.Replace(node)
 			}
@@ -874,7 +1620,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		// binary expressions like long string concatenations, where a line break
 		// after the assignment token can improve readability.
 		if len(node.Rhs) == 1 {
-			if _, ok := node.Rhs[0].(*ast.BinaryExpr); !ok {
+			if _, ok := node.Rhs[0].(*ast.BinaryExpr); !ok && f.useRule(ruleAssignmentNewlines) {
 				f.removeLines(f.Line(node.TokPos), f.Line(node.Rhs[0].Pos()))
 			}
 		}
@@ -883,7 +1629,7 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 		if len(node.Results) > 0 {
 			break
 		}
-		if !f.Extra.ClotheReturns {
+		if !f.Extra.ClotheReturns || !f.useRule(ruleClotheReturns) {
 			break
 		}
 		results := f.parentFuncTypes[len(f.parentFuncTypes)-1].Results
@@ -909,11 +1655,396 @@ func (f *fumpter) applyPre(c *astutil.Cursor) {
 			}
 		}
 		if len(node.Results) > 0 {
+			f.changed(node.Pos(), node.End())
 			c.Replace(node)
 		}
 	}
 }
 
+// findOffRegions finds the regions of file where gofumpt's rules are turned off.
+// A //gofumpt:off comment turns the rules off until the next //gofumpt:on
+// comment, or until the end of the file.
+// A //gofumpt:ignore comment turns them off for the next top-level declaration,
+// or inside a declaration, for the next statement or spec in the same list.
+func (f *fumpter) findOffRegions(file *ast.File) {
+	f.offRegions = nil
+	offPos := token.NoPos
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			switch comment.Text {
+			case "//gofumpt:off":
+				if !offPos.IsValid() {
+					offPos = comment.Pos()
+				}
+			case "//gofumpt:on":
+				if offPos.IsValid() {
+					f.offRegions = append(f.offRegions, posRange{offPos, comment.End()})
+					offPos = token.NoPos
+				}
+			case "//gofumpt:ignore":
+				if offPos.IsValid() {
+					break // already within a region
+				}
+				i := slices.IndexFunc(file.Decls, func(decl ast.Decl) bool {
+					return decl.Pos() > comment.Pos()
+				})
+				if i < 0 {
+					i = len(file.Decls) // after all declarations
+				}
+				var next ast.Node
+				if i > 0 && file.Decls[i-1].End() > comment.Pos() {
+					next = nextInList(file, comment)
+				} else if i < len(file.Decls) {
+					next = file.Decls[i]
+				}
+				if next != nil {
+					f.offRegions = append(f.offRegions, posRange{comment.Pos(), next.End()})
+				}
+			}
+		}
+	}
+	if offPos.IsValid() {
+		f.offRegions = append(f.offRegions, posRange{offPos, file.FileEnd})
+	}
+}
+
+// nextInList returns the statement or spec following a comment inside a
+// declaration, in the innermost list of statements or specs enclosing it.
+// It returns nil if there is no such node, such as at the end of a block.
+func nextInList(file *ast.File, comment *ast.Comment) ast.Node {
+	path, _ := astutil.PathEnclosingInterval(file, comment.Pos(), comment.End())
+	for _, node := range path {
+		var list []ast.Node
+		switch node := node.(type) {
+		case *ast.BlockStmt:
+			list = stmtNodes(node.List)
+		case *ast.CaseClause:
+			list = stmtNodes(node.Body)
+		case *ast.CommClause:
+			list = stmtNodes(node.Body)
+		case *ast.GenDecl:
+			for _, spec := range node.Specs {
+				list = append(list, spec)
+			}
+		default:
+			continue
+		}
+		i := slices.IndexFunc(list, func(node ast.Node) bool {
+			return node.Pos() > comment.Pos()
+		})
+		if i < 0 {
+			return nil
+		}
+		return list[i]
+	}
+	return nil
+}
+
+func stmtNodes(stmts []ast.Stmt) []ast.Node {
+	nodes := make([]ast.Node, len(stmts))
+	for i, stmt := range stmts {
+		nodes[i] = stmt
+	}
+	return nodes
+}
+
+// offRegion reports whether node overlaps with any region where gofumpt's
+// rules are turned off, and whether it is entirely within one.
+// The file node itself is never considered to overlap,
+// as its rules skip the regions on their own.
+func (f *fumpter) offRegion(node ast.Node) (overlaps, within bool) {
+	if _, ok := node.(*ast.File); ok || node == nil {
+		return false, false
+	}
+	for _, r := range f.offRegions {
+		if r.overlaps(node) {
+			return true, r.pos <= node.Pos() && node.End() <= r.end
+		}
+	}
+	return false, false
+}
+
+// inRanges reports whether node overlaps with the ranges we were asked to
+// format, if any. Like offRegion, the file node is always in range.
+func (f *fumpter) inRanges(node ast.Node) bool {
+	if _, ok := node.(*ast.File); ok || node == nil || f.ranges == nil {
+		return true
+	}
+	for _, r := range f.ranges {
+		if r.overlaps(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope reports whether gofumpt's rules may act on node, which is part of
+// a larger node such as a file, as it is within the ranges we were asked to
+// format and not in a region where the rules are turned off.
+func (f *fumpter) inScope(node ast.Node) bool {
+	overlaps, _ := f.offRegion(node)
+	return f.inRanges(node) && !overlaps
+}
+
+// joinContiguousDecls joins contiguous lone var/const/import lines.
+// It stops joining at empty lines in between,
+// including a leading comment if it's a directive.
+func (f *fumpter) joinContiguousDecls(file *ast.File) {
+	newDecls := make([]ast.Decl, 0, len(file.Decls))
+	for i := 0; i < len(file.Decls); {
+		newDecls = append(newDecls, file.Decls[i])
+		start, ok := file.Decls[i].(*ast.GenDecl)
+		if !ok || isCgoImport(start) || containsAnyDirective(start.Doc) || !f.inScope(start) {
+			i++
+			continue
+		}
+		lastPos := start.Pos()
+		merged := false
+	contLoop:
+		for i++; i < len(file.Decls); {
+			cont, ok := file.Decls[i].(*ast.GenDecl)
+			if !ok || cont.Tok != start.Tok || cont.Lparen != token.NoPos || isCgoImport(cont) || !f.inScope(cont) {
+				break
+			}
+			// Are there things between these two declarations? e.g. empty lines, comments, directives
+			// If so, break the chain on empty lines and directives, continue below for comments.
+			if f.Line(lastPos) < f.Line(cont.Pos())-1 {
+				// break on empty line
+				if cont.Doc == nil {
+					break
+				}
+				// break on directive
+				for i, comment := range cont.Doc.List {
+					if f.Line(comment.Slash) != f.Line(lastPos)+1+i || rxCommentDirective.MatchString(strings.TrimPrefix(comment.Text, "//")) {
+						break contLoop
+					}
+				}
+				// continue below for comments
+			}
+
+			f.changed(start.Pos(), cont.End())
+			start.Specs = append(start.Specs, cont.Specs...)
+			merged = true
+			end := cont.End()
+			if c := f.inlineComment(cont.End()); c != nil {
+				// don't move an inline comment outside
+				end = c.End()
+			}
+			// Point Rparen at the last content character, like a real
+			// ')', so start.End() stays on the content's final line and
+			// separateMultilineDecls is idempotent in one pass.
+			start.Rparen = end - 1
+			lastPos = cont.Pos()
+			i++
+		}
+		// Re-sort imports in the new group so the output is idempotent.
+		// Set Lparen so ast.SortImports doesn't skip the merged decl.
+		if merged && start.Tok == token.IMPORT {
+			start.Lparen = start.TokPos + token.Pos(len("import"))
+			ast.SortImports(f.fset, f.astFile)
+		}
+	}
+	file.Decls = newDecls
+}
+
+// mergeImportDecls merges each run of import declarations into the first one,
+// so that a file's imports end up in a single block. Runs are broken by cgo
+// imports, which need their own declaration for the preamble comment.
+func (f *fumpter) mergeImportDecls(file *ast.File) {
+	newDecls := make([]ast.Decl, 0, len(file.Decls))
+	var into *ast.GenDecl
+	merged := false
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT || importsCgo(d) ||
+			containsAnyDirective(d.Doc) || !f.inScope(d) {
+			into = nil
+			newDecls = append(newDecls, decl)
+			continue
+		}
+		// Comments among the imports would not move along with them
+		// when they get regrouped, so leave such declarations alone.
+		if len(f.commentsBetween(d.Pos(), d.End())) > 0 || f.inlineComment(d.End()) != nil {
+			into = nil
+			newDecls = append(newDecls, decl)
+			continue
+		}
+		// Likewise with a doc comment, which would end up above the first
+		// import, but the declaration can still be merged into.
+		if into == nil || d.Doc != nil {
+			into = d
+			newDecls = append(newDecls, decl)
+			continue
+		}
+
+		// Extend the first block to cover this declaration,
+		// so that any comments in between stay where they are.
+		f.changed(into.Pos(), d.End())
+		if !into.Lparen.IsValid() {
+			into.Lparen = into.TokPos + token.Pos(len("import"))
+		}
+		into.Specs = append(into.Specs, d.Specs...)
+		if f.mergedImports == nil {
+			f.mergedImports = make(map[*ast.ImportSpec]bool)
+		}
+		for _, spec := range d.Specs {
+			f.mergedImports[spec.(*ast.ImportSpec)] = true
+		}
+		if d.Rparen.IsValid() {
+			into.Rparen = d.Rparen
+		} else {
+			// Like in joinContiguousDecls, point Rparen at the last content
+			// character.
+			into.Rparen = d.End() - 1
+		}
+		merged = true
+	}
+	file.Decls = newDecls
+	if merged {
+		ast.SortImports(f.fset, f.astFile)
+	}
+}
+
+// importsCgo reports whether an import declaration includes the "C" import.
+func importsCgo(decl *ast.GenDecl) bool {
+	for _, spec := range decl.Specs {
+		if importPath(spec.(*ast.ImportSpec)) == "C" {
+			return true
+		}
+	}
+	return false
+}
+
+// importPath returns the unquoted path of an import spec.
+func importPath(spec *ast.ImportSpec) string {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		panic(err) // should never error
+	}
+	return path
+}
+
+var rxMajorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// removeRedundantAliases removes names from standard library imports which are
+// the same as the last element of the import path, which is the package name.
+// Other packages may use any name, which we cannot know without loading them.
+// Major version suffixes like "v2" are not package names.
+func (f *fumpter) removeRedundantAliases(file *ast.File) {
+	for _, spec := range file.Imports {
+		if spec.Name == nil || !f.inScope(spec) {
+			continue
+		}
+		path := importPath(spec)
+		base := pathpkg.Base(path)
+		if spec.Name.Name != base || base == "_" || path == "C" || !f.isStdImport(path) ||
+			rxMajorVersion.MatchString(base) {
+			continue
+		}
+		f.changed(spec.Pos(), spec.End())
+		spec.Name = nil
+	}
+}
+
+// removeDuplicateImports removes the import specs which repeat an earlier
+// import with the same name and path. Dot, blank, and cgo imports are kept,
+// as well as duplicates with comments.
+func (f *fumpter) removeDuplicateImports(file *ast.File) {
+	type key struct{ name, path string }
+	seen := make(map[key]bool)
+	newDecls := make([]ast.Decl, 0, len(file.Decls))
+	removed := false
+	for _, decl := range file.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT || importsCgo(d) {
+			newDecls = append(newDecls, decl)
+			continue
+		}
+		var specs []ast.Spec
+		lastLine := 0
+		for _, spec := range d.Specs {
+			spec := spec.(*ast.ImportSpec)
+			k := key{path: importPath(spec)}
+			if spec.Name != nil {
+				k.name = spec.Name.Name
+			}
+			hasComment := spec.Doc != nil || spec.Comment != nil ||
+				(!d.Lparen.IsValid() && d.Doc != nil)
+			if !seen[k] || k.name == "_" || k.name == "." || hasComment || !f.inScope(spec) {
+				seen[k] = true
+				specs = append(specs, spec)
+				lastLine = f.Line(spec.End())
+				continue
+			}
+			removed = true
+			f.changed(spec.Pos(), spec.End())
+			// Like ast.SortImports, remove the line if the spec was alone in it.
+			if line := f.Line(spec.Pos()); d.Lparen.IsValid() &&
+				line > lastLine && line == f.Line(spec.End()) && line < f.Line(d.Rparen) {
+				f.removeLines(line, line+1)
+			}
+		}
+		if len(specs) == 0 {
+			continue // every import was a duplicate
+		}
+		d.Specs = specs
+		newDecls = append(newDecls, decl)
+	}
+	if !removed {
+		return
+	}
+	file.Decls = newDecls
+	file.Imports = file.Imports[:0]
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			for _, spec := range d.Specs {
+				file.Imports = append(file.Imports, spec.(*ast.ImportSpec))
+			}
+		}
+	}
+}
+
+// separateMultilineDecls ensures that multiline top-level declarations are
+// separated by an empty line.
+func (f *fumpter) separateMultilineDecls(file *ast.File) {
+	var lastMulti bool
+	var lastEnd token.Pos
+	lastInScope := false
+	for _, decl := range file.Decls {
+		pos := decl.Pos()
+		// Trailing inline comments on lastEnd's line belong to the
+		// previous decl and extend its effective end.
+		effectiveEnd := lastEnd
+		lastEndLine := f.Line(lastEnd)
+		for _, cg := range f.commentsBetween(lastEnd, pos) {
+			if f.Line(cg.Pos()) != lastEndLine {
+				pos = cg.Pos()
+				break
+			}
+			effectiveEnd = cg.End()
+		}
+
+		// Note that we want End-1, as End is the character after the node.
+		multi := f.Line(pos) < f.Line(decl.End()-1)
+		// A func declaration which fits on a single source line may
+		// still be printed across multiple lines: go/printer's funcBody
+		// breaks the body onto its own lines once header+body exceeds
+		// 100 bytes. Approximate that with the source byte length.
+		if fn, _ := decl.(*ast.FuncDecl); fn != nil && !multi && fn.Body != nil &&
+			f.Offset(fn.End())-f.Offset(fn.Pos()) > 100 {
+			multi = true
+		}
+		inScope := f.inScope(decl)
+		if multi && lastMulti && inScope && lastInScope && f.Line(effectiveEnd)+1 == f.Line(pos) {
+			f.addNewline(effectiveEnd)
+		}
+
+		lastMulti = multi
+		lastEnd = decl.End()
+		lastInScope = inScope
+	}
+}
+
 func (f *fumpter) applyPost(c *astutil.Cursor) {
 	switch node := c.Node().(type) {
 	// Adding newlines to composite literals happens as a "post" step, so
@@ -946,7 +2077,9 @@ func (f *fumpter) applyPost(c *astutil.Cursor) {
 					newlineAroundElems = true
 
 					// remove leading lines if they exist
-					f.removeLines(openLine+1, curLine)
+					if f.useRule(ruleCompositeLeading) {
+						f.removeLines(openLine+1, curLine)
+					}
 				} else {
 					newlineBetweenElems = true
 				}
@@ -957,6 +2090,9 @@ func (f *fumpter) applyPost(c *astutil.Cursor) {
 		if closeLine > lastLine {
 			newlineAroundElems = true
 		}
+		if !f.useRule(ruleCompositeMultiline) {
+			break
+		}
 
 		if newlineBetweenElems || newlineAroundElems {
 			first := node.Elts[0]
@@ -1014,16 +2150,14 @@ func (f *fumpter) applyPost(c *astutil.Cursor) {
 		lastLine := f.Line(lastEnd)
 		openAtEOL := openLine != firstLine
 		closeAtBOL := closeLine != lastLine
-		if openAtEOL && !closeAtBOL {
+		if openAtEOL && !closeAtBOL && f.useRule(ruleCallMultiline) {
 			f.addNewline(node.Rparen)
 		}
 	}
 }
 
 func (f *fumpter) splitLongLine(c *astutil.Cursor) {
-	if os.Getenv("GOFUMPT_SPLIT_LONG_LINES") != "on" {
-		// By default, this feature is turned off.
-		// Turn it on by setting GOFUMPT_SPLIT_LONG_LINES=on.
+	if !f.Extra.SplitLongLines {
 		return
 	}
 	node := c.Node()
@@ -1049,19 +2183,22 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 		return
 	}
 
-	// Like in printLength, add an approximation of the indentation level.
-	// Since any existing tabs were already counted as one column, multiply
-	// the level by 7.
-	startCol := start.Column + f.blockLevel*7
-	endCol := end.Column + f.blockLevel*7
+	span, ok := f.measure(nil, node.Pos(), node.End())
+	if !ok {
+		return
+	}
+	startCol := span.Column
+	endCol := span.Column + span.Width
 
 	// If this is a composite literal,
 	// and we were going to insert a newline before the entire literal,
 	// insert the newline before the first element instead.
 	// Since we'll add a newline after the last element too,
 	// this format is generally going to be nicer.
+	list := c.Parent()
 	if comp := isComposite(node); comp != nil && len(comp.Elts) > 0 {
 		newlinePos = comp.Elts[0].Pos()
+		list = comp
 	}
 
 	// If this is a function call,
@@ -1075,20 +2212,24 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 		startCol += (first.Column - start.Column) / 2
 	}
 
+	lineLength := f.LineLength
+	if lineLength <= 0 {
+		lineLength = defaultLineLength
+	}
+
 	// If the start position is too short, we definitely won't split the line.
-	if startCol <= shortLineLimit {
+	// The short limit scales with the line length.
+	if startCol <= shortLineLimit*lineLength/defaultLineLength {
 		return
 	}
 
-	lineEnd := f.Position(f.lineEnd(start.Line))
-
 	// firstLength and secondLength are the split line lengths, excluding
 	// indentation.
-	firstLength := start.Column - f.blockLevel
+	firstLength := span.Column - span.Indent
 	if firstLength < 0 {
 		panic("negative length")
 	}
-	secondLength := lineEnd.Column - start.Column
+	secondLength := span.LineWidth + 1 - span.Column
 	if secondLength < 0 {
 		panic("negative length")
 	}
@@ -1096,10 +2237,11 @@ func (f *fumpter) splitLongLine(c *astutil.Cursor) {
 	// If the line ends past the long line limit,
 	// and both splits are estimated to take at least minSplitFactor of the limit,
 	// then split the line.
-	minSplitLength := int(f.minSplitFactor * longLineLimit)
-	if endCol > longLineLimit &&
-		firstLength >= minSplitLength && secondLength >= minSplitLength {
-		f.addNewline(newlinePos)
+	minSplitLength := int(f.minSplitFactor * float64(lineLength))
+	if endCol > lineLength &&
+		firstLength >= minSplitLength && secondLength >= minSplitLength &&
+		f.useRule(ruleSplitLongLines) {
+		f.split(newlinePos, list)
 	}
 }
 
@@ -1155,6 +2297,9 @@ func isComposite(node ast.Node) *ast.CompositeLit {
 }
 
 func (f *fumpter) stmts(list []ast.Stmt) {
+	if !f.useRule(ruleNewlineErrcheck) {
+		return
+	}
 	for i, stmt := range list {
 		ifs, ok := stmt.(*ast.IfStmt)
 		if !ok || i < 1 {
@@ -1211,22 +2356,6 @@ func (f *fumpter) joinStdImports(d *ast.GenDecl) {
 	lastEnd := d.Pos()
 	needsSort := false
 
-	// If ModulePath is "foo/bar", we assume "foo/..." is not part of std.
-	// Users shouldn't declare modules that may collide with std this way,
-	// but historically some private codebases have done so.
-	// This is a relatively harmless way to make gofumpt compatible with them,
-	// as it changes nothing for the common external module paths.
-	var modulePrefix string
-	if f.ModulePath == "" {
-		// Nothing to do.
-	} else if i := strings.IndexByte(f.ModulePath, '/'); i != -1 {
-		// ModulePath is "foo/bar", so we use "foo" as the prefix.
-		modulePrefix = f.ModulePath[:i]
-	} else {
-		// ModulePath is "foo", so we use "foo" as the prefix.
-		modulePrefix = f.ModulePath
-	}
-
 	for i, spec := range d.Specs {
 		spec := spec.(*ast.ImportSpec)
 		if coms := f.commentsBetween(lastEnd, spec.Pos()); len(coms) > 0 {
@@ -1239,36 +2368,26 @@ func (f *fumpter) joinStdImports(d *ast.GenDecl) {
 			lastEnd = spec.End()
 		}
 
-		path, err := strconv.Unquote(spec.Path.Value)
-		if err != nil {
-			panic(err) // should never error
-		}
-		periodIndex := strings.IndexByte(path, '.')
-		slashIndex := strings.IndexByte(path, '/')
 		switch {
-		// Imports with a period in the first path element are third party.
-		// Note that this includes "foo.com" and excludes "foo/bar.com/baz".
-		case periodIndex > 0 && (slashIndex == -1 || periodIndex < slashIndex),
-
-			// "test" and "example" are reserved as per golang.org/issue/37641.
-			strings.HasPrefix(path, "test/"),
-			strings.HasPrefix(path, "example/"),
-
-			// See if we match modulePrefix; see its documentation above.
-			// We match either exactly or with a slash suffix,
-			// so that the prefix "foo" for "foo/..." does not match "foobar".
-			path == modulePrefix || strings.HasPrefix(path, modulePrefix+"/"),
+		case !f.isStdImport(importPath(spec)),
 
 			// To be conservative, if an import has a name or an inline
 			// comment, and isn't part of the top group, treat it as non-std.
-			!firstGroup && (spec.Name != nil || spec.Comment != nil):
+			// Named imports merged from a later declaration were never
+			// grouped with the ones here, so they are classified by path.
+			!firstGroup && (spec.Name != nil && !f.mergedImports[spec] || spec.Comment != nil):
 			other = append(other, spec)
 			continue
 		}
 
 		// If we're moving this std import further up, reset its
 		// position, to avoid breaking comments.
+		// Its doc comment would not move with it, so leave it alone.
 		if !firstGroup || len(other) > 0 {
+			if spec.Doc != nil {
+				other = append(other, spec)
+				continue
+			}
 			setPos(reflect.ValueOf(spec), d.Pos())
 			needsSort = true
 		}
@@ -1289,10 +2408,130 @@ func (f *fumpter) joinStdImports(d *ast.GenDecl) {
 	// If we moved any std imports to the first group, we need to sort them
 	// again.
 	if needsSort {
+		f.changed(d.Pos(), d.End())
 		ast.SortImports(f.fset, f.astFile)
 	}
 }
 
+// isStdImport reports whether an import path belongs to the standard library,
+// going by the path alone.
+func (f *fumpter) isStdImport(path string) bool {
+	// If ModulePath is "foo/bar", we assume "foo/..." is not part of std.
+	// Users shouldn't declare modules that may collide with std this way,
+	// but historically some private codebases have done so.
+	// This is a relatively harmless way to make gofumpt compatible with them,
+	// as it changes nothing for the common external module paths.
+	var modulePrefix string
+	if f.ModulePath == "" {
+		// Nothing to do.
+	} else if i := strings.IndexByte(f.ModulePath, '/'); i != -1 {
+		// ModulePath is "foo/bar", so we use "foo" as the prefix.
+		modulePrefix = f.ModulePath[:i]
+	} else {
+		// ModulePath is "foo", so we use "foo" as the prefix.
+		modulePrefix = f.ModulePath
+	}
+
+	periodIndex := strings.IndexByte(path, '.')
+	slashIndex := strings.IndexByte(path, '/')
+	switch {
+	// Imports with a period in the first path element are third party.
+	// Note that this includes "foo.com" and excludes "foo/bar.com/baz".
+	case periodIndex > 0 && (slashIndex == -1 || periodIndex < slashIndex),
+
+		// "test" and "example" are reserved as per golang.org/issue/37641.
+		strings.HasPrefix(path, "test/"),
+		strings.HasPrefix(path, "example/"),
+
+		// See if we match modulePrefix; see its documentation above.
+		// We match either exactly or with a slash suffix,
+		// so that the prefix "foo" for "foo/..." does not match "foobar".
+		path == modulePrefix || strings.HasPrefix(path, modulePrefix+"/"),
+
+		// Local imports are never std, even without a period.
+		f.isLocalImport(path):
+		return false
+	}
+	return true
+}
+
+// isLocalImport reports whether an import path matches [Options.LocalPrefixes].
+func (f *fumpter) isLocalImport(path string) bool {
+	for _, prefix := range f.LocalPrefixes {
+		if strings.HasPrefix(path, prefix) || path == strings.TrimSuffix(prefix, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// joinLocalImports ensures that all local imports are together and at the
+// bottom of an imports block, separated from the other imports.
+func (f *fumpter) joinLocalImports(d *ast.GenDecl) {
+	isLocal := func(spec ast.Spec) bool {
+		return f.isLocalImport(importPath(spec.(*ast.ImportSpec)))
+	}
+	lastOther := -1
+	for i, spec := range d.Specs {
+		if !isLocal(spec) {
+			lastOther = i
+		}
+	}
+	if lastOther < 0 {
+		return // only local imports
+	}
+
+	// Local imports before the last non-local import are moved to the end,
+	// unless they have comments, which we don't want to break.
+	var kept, moved []ast.Spec
+	for i, spec := range d.Specs {
+		imp := spec.(*ast.ImportSpec)
+		if i < lastOther && isLocal(spec) && imp.Doc == nil && imp.Comment == nil {
+			moved = append(moved, spec)
+		} else {
+			kept = append(kept, spec)
+		}
+	}
+	lastOther -= len(moved)
+
+	// Ensure there is an empty line between the other imports and local imports.
+	if lastOther+1 < len(kept) {
+		first := kept[lastOther+1]
+		if f.Line(first.Pos()) <= f.Line(kept[lastOther].End())+1 {
+			// Like in joinStdImports, we add two newlines for edge cases.
+			f.addNewline(first.Pos() - 1)
+			f.addNewline(first.Pos())
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	// Move the local imports to the line with the closing parenthesis,
+	// so that they are sorted along with any local imports already at the end.
+	pos := d.Rparen
+	if lastOther+1 == len(kept) {
+		// There are no local imports at the end yet, and there may not be
+		// any room for an empty line before the closing parenthesis.
+		// Move both to the next line, which ast.SortImports sees as a new run.
+		//
+		// If the last import ends right at the closing parenthesis,
+		// we can't separate them, so wait for the printer to split them.
+		pos++
+		if f.Offset(pos) >= f.file.Size() || f.Line(kept[lastOther].End()) == f.Line(d.Rparen) {
+			return
+		}
+		f.addNewline(pos)
+		d.Rparen = pos
+	}
+	f.changed(d.Pos(), d.End())
+	for _, spec := range moved {
+		setPos(reflect.ValueOf(spec), pos)
+	}
+	d.Specs = append(kept, moved...)
+	ast.SortImports(f.fset, f.astFile)
+}
+
 // mergeAdjacentFields returns fields with adjacent fields merged if possible.
 func (f *fumpter) mergeAdjacentFields(fields []*ast.Field) []*ast.Field {
 	// If there are less than two fields then there is nothing to merge.
@@ -1307,6 +2546,7 @@ func (f *fumpter) mergeAdjacentFields(fields []*ast.Field) []*ast.Field {
 	i := 0
 	for j := 1; j < len(fields); j++ {
 		if f.shouldMergeAdjacentFields(fields[i], fields[j]) {
+			f.changed(fields[i].Pos(), fields[j].End())
 			fields[i].Names = append(fields[i].Names, fields[j].Names...)
 		} else {
 			i++
@@ -1340,10 +2580,10 @@ func (f *fumpter) shouldMergeAdjacentFields(f1, f2 *ast.Field) bool {
 	}
 	emptyFset := token.NewFileSet()
 	var b1, b2 bytes.Buffer
-	if err := format.Node(&b1, emptyFset, f1.Type); err != nil {
+	if err := goformat.Node(&b1, emptyFset, f1.Type); err != nil {
 		return false
 	}
-	if err := format.Node(&b2, emptyFset, f2.Type); err != nil {
+	if err := goformat.Node(&b2, emptyFset, f2.Type); err != nil {
 		return false
 	}
 	return bytes.Equal(b1.Bytes(), b2.Bytes())
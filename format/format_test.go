@@ -4,6 +4,11 @@
 package format_test
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/go-quicktest/qt"
@@ -36,3 +41,284 @@ func f() {
 	qt.Assert(t, qt.IsNil(err))
 	qt.Assert(t, qt.Equals(string(got), string(want)))
 }
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`
+package p
+
+import (
+	"foo.local/bar"
+	"os"
+)
+
+func f() {
+
+	var x = 0755
+	println(x, os.Args, bar.X)
+}
+`[1:])
+	diags, err := format.Check(in, format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNil(err))
+
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	qt.Assert(t, qt.DeepEquals(got, []string{
+		"3:1: std imports grouped (std-imports)",
+		"9:1: empty lines around function body removed (func-body)",
+		"10:2: var declaration replaced with short assignment (short-decl)",
+		"10:10: octal literal prefixed (octal-literals)",
+	}))
+
+	// Well formatted source has no diagnostics.
+	got2, err := format.Check([]byte("package p\n\nvar x = 0o755\n"), format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(got2, 0))
+
+	// Like Source, program fragments are only accepted with AllowFragments,
+	// and positions are relative to the fragment.
+	in = []byte("\tvar x = 0755\n\n\tif err != nil {\n\n\t\treturn err\n\t}\n")
+	_, err = format.Check(in, format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNotNil(err))
+	diags, err = format.Check(in, format.Options{LangVersion: "go1.16", AllowFragments: true})
+	qt.Assert(t, qt.IsNil(err))
+	got = nil
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	qt.Assert(t, qt.DeepEquals(got, []string{
+		"1:2: var declaration replaced with short assignment (short-decl)",
+		"1:10: octal literal prefixed (octal-literals)",
+		"4:1: empty lines around lone statement removed (block-single)",
+	}))
+}
+
+func TestSourceRange(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`
+package p
+
+var single = "foo"
+var another = "bar"
+
+func f() {
+
+	println("f")
+}
+
+func g() {
+
+	println("g")
+}
+`[1:])
+	// Select from the start of g until its body.
+	start := bytes.Index(in, []byte("func g"))
+	end := bytes.Index(in, []byte(`println("g")`))
+	want := []byte(`
+package p
+
+var single = "foo"
+var another = "bar"
+
+func f() {
+
+	println("f")
+}
+
+func g() {
+	println("g")
+}
+`[1:])
+	got, err := format.SourceRange(in, start, end, format.Options{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(got), string(want)))
+
+	// A range covering the entire source is like Source.
+	got, err = format.SourceRange(in, 0, len(in), format.Options{})
+	qt.Assert(t, qt.IsNil(err))
+	want, err = format.Source(in, format.Options{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(got), string(want)))
+
+	_, err = format.SourceRange(in, 10, 5, format.Options{})
+	qt.Assert(t, qt.ErrorMatches(err, `invalid range 10-5 .*`))
+}
+
+func TestEdits(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`
+package p
+
+func f() {
+
+	var x = 0755
+	println(x)
+}
+`[1:])
+	edits, err := format.Edits(in, format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(edits, []format.Edit{
+		{Start: 22, End: 33, New: "\tx := 0o"},
+	}))
+
+	// Applying the edits results in the formatted source.
+	want, err := format.Source(in, format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNil(err))
+	var got []byte
+	last := 0
+	for _, e := range edits {
+		got = append(got, in[last:e.Start]...)
+		got = append(got, e.New...)
+		last = e.End
+	}
+	got = append(got, in[last:]...)
+	qt.Assert(t, qt.Equals(string(got), string(want)))
+
+	edits, err = format.Edits(want, format.Options{LangVersion: "go1.16"})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(edits))
+}
+
+func TestRangeEdits(t *testing.T) {
+	t.Parallel()
+
+	in := []byte(`
+package p
+
+func f() {
+
+	println(1+ 2)
+}
+
+func g() {
+
+	println("g")
+}
+`[1:])
+	// Only g's body is formatted; f's gofmt change is left alone as well.
+	start := bytes.Index(in, []byte("func g"))
+	edits, err := format.RangeEdits(in, start, len(in), format.Options{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(edits, []format.Edit{
+		{Start: start + 11, End: start + 12, New: ""},
+	}))
+}
+
+func TestSourceFragments(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in, want string
+	}{
+		{
+			"var x = 0755\nvar y = 1\n",
+			"var (\n\tx = 0o755\n\ty = 1\n)\n",
+		},
+		{
+			"\tvar x = 1\n\n\tif err != nil {\n\n\t\treturn err\n\t}\n",
+			"\tx := 1\n\n\tif err != nil {\n\t\treturn err\n\t}\n",
+		},
+	}
+	for _, test := range tests {
+		_, err := format.Source([]byte(test.in), format.Options{})
+		qt.Assert(t, qt.IsNotNil(err))
+
+		got, err := format.Source([]byte(test.in), format.Options{
+			LangVersion:    "go1.16",
+			AllowFragments: true,
+		})
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.Equals(string(got), test.want))
+	}
+}
+
+func TestOptionsForFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	qt.Assert(t, qt.IsNil(os.WriteFile(filepath.Join(dir, "go.mod"),
+		[]byte("module example.com/foo\n\ngo 1.21\n"), 0o666)))
+	qt.Assert(t, qt.IsNil(os.Mkdir(filepath.Join(dir, "sub"), 0o777)))
+
+	opts, err := format.OptionsForFile(filepath.Join(dir, "sub", "foo.go"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(opts.LangVersion, "go1.21"))
+	qt.Assert(t, qt.Equals(opts.ModulePath, "example.com/foo"))
+
+	// A gofumpt.toml file takes precedence over go.mod.
+	writeFile := func(path, content string) {
+		path = filepath.Join(dir, path)
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o777)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(content), 0o666)))
+	}
+	writeFile("configured/gofumpt.toml", "lang = \"go1.22\"\nextra = [\"group_params\"]\nline-length = 80\n")
+	opts, err = format.OptionsForFile(filepath.Join(dir, "configured", "sub", "foo.go"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(opts.LangVersion, "go1.22"))
+	qt.Assert(t, qt.Equals(opts.ModulePath, "example.com/foo"))
+	qt.Assert(t, qt.Equals(opts.Extra, format.Extra{GroupParams: true}))
+	qt.Assert(t, qt.Equals(opts.LineLength, 80))
+
+	writeFile("invalid/gofumpt.toml", "extra = [\"unknown\"]\n")
+	_, err = format.OptionsForFile(filepath.Join(dir, "invalid", "foo.go"))
+	qt.Assert(t, qt.ErrorMatches(err, `.*gofumpt\.toml: extra: unknown rule: "unknown"`))
+
+	// Without a go.mod file, we cannot fill any options.
+	opts, err = format.OptionsForFile(filepath.Join(t.TempDir(), "foo.go"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(opts.LangVersion, ""))
+	qt.Assert(t, qt.Equals(opts.ModulePath, ""))
+}
+
+// largeTables returns a file with tables of n elements, the first printed on
+// a single line like generated code often is, and the second with a call per
+// element which is too long, like table-driven tests often are.
+func largeTables(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("package p\n\nvar table = [...]byte{")
+	for i := range n {
+		fmt.Fprintf(&b, "0x%02x, ", i%256)
+	}
+	b.WriteString("}\n\nvar calls = []any{\n")
+	for i := range n {
+		fmt.Fprintf(&b, "\tfoo(%d, \"some string argument\", \"another string argument\", bar(baz, qux, %d)),\n", i, i)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func TestSplitLongLinesLargeTables(t *testing.T) {
+	t.Parallel()
+
+	// Measuring lines again after every split used to take quadratic time,
+	// which took minutes with large enough tables.
+	opts := format.Options{Extra: format.Extra{SplitLongLines: true}}
+	got, err := format.Source(largeTables(10_000), opts)
+	qt.Assert(t, qt.IsNil(err))
+	for i, line := range strings.Split(string(got), "\n") {
+		if width := len(strings.ReplaceAll(line, "\t", "        ")); width > 100 {
+			t.Fatalf("line %d is %d columns wide: %q", i+1, width, line)
+		}
+	}
+
+	// The result is stable.
+	got2, err := format.Source(got, opts)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(got2), string(got)))
+}
+
+func BenchmarkSplitLongLines(b *testing.B) {
+	src := largeTables(5_000)
+	opts := format.Options{Extra: format.Extra{SplitLongLines: true}}
+	b.SetBytes(int64(len(src)))
+	for b.Loop() {
+		if _, err := format.Source(src, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -51,6 +51,9 @@ func FuzzFormat(f *testing.F) {
 		qt.Assert(t, qt.IsNil(err))
 		_ = formatted
 
+		_, err = Check(orig, opts)
+		qt.Assert(t, qt.IsNil(err))
+
 		// TODO: verify that the result is idempotent
 
 		// TODO: verify that, if the input was valid Go 1.N syntax,
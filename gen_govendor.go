@@ -8,7 +8,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path"
@@ -29,7 +31,34 @@ var toVendor = []string{
 	"internal/diff",
 }
 
+// gofumptFile is the name of the files in vendored packages which hold
+// additions by gofumpt, which must be kept when re-vendoring.
+const gofumptFile = "gofumpt.go"
+
+// patches are minimal changes to vendored files, keyed by their path relative
+// to vendorDir, to hook them into the additions in gofumptFile.
+// Re-vendoring fails if a patch no longer applies.
+var patches = map[string][][2]string{
+	"go/printer/printer.go": {
+		{
+			"\tcachedLine int // line corresponding to cachedPos\n",
+			"\tcachedLine int // line corresponding to cachedPos\n\n\tmeasure *measurer // NOTE(gofumpt): see gofumpt.go\n",
+		},
+		{
+			"\tp.output = append(p.output, s...)\n",
+			"\tp.output = append(p.output, s...)\n\tif p.measure != nil { // NOTE(gofumpt): see gofumpt.go\n\t\tp.measure.wrote(pos, len(p.output)-len(s), len(p.output))\n\t}\n",
+		},
+	},
+}
+
 func main() {
+	kept := make(map[string][]byte)
+	catch(filepath.WalkDir(vendorDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Name() == gofumptFile {
+			kept[path], err = os.ReadFile(path)
+		}
+		return err
+	}))
 	catch(os.RemoveAll(vendorDir))
 
 	catch(os.MkdirAll(vendorDir, 0o777))
@@ -75,11 +104,21 @@ func main() {
 			catch(err)
 
 			src := replacer.Replace(string(srcBytes))
+			for _, patch := range patches[path.Join(dstPkg, goFile)] {
+				if !strings.Contains(src, patch[0]) {
+					panic(fmt.Sprintf("patch for %s/%s no longer applies: %q", dstPkg, goFile, patch[0]))
+				}
+				src = strings.Replace(src, patch[0], patch[1], 1)
+			}
 
 			dst := filepath.Join(dstDir, goFile)
 			catch(os.WriteFile(dst, []byte(src), 0o666))
 		}
 	}
+	for path, src := range kept {
+		catch(os.MkdirAll(filepath.Dir(path), 0o777))
+		catch(os.WriteFile(path, src, 0o666))
+	}
 }
 
 func catch(err error) {
@@ -3,6 +3,7 @@ module mvdan.cc/gofumpt
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/go-quicktest/qt v1.102.0
 	github.com/rogpeppe/go-internal v1.14.1
 	golang.org/x/mod v0.35.0
@@ -21,22 +21,32 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/pprof"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 
-	// NOTE(gofumpt): x/mod/modfile is used to read each file's go.mod for the
-	// default -lang and -modpath, and to honor `ignore` directives.
-	"golang.org/x/mod/modfile"
 	"golang.org/x/sync/semaphore"
 
 	// NOTE(gofumpt): the format package exposes gofumpt's added rules and
-	// simplification as a public Go API. diff and go/printer are vendored
-	// copies frozen at a specific Go version, so gofumpt's output is
-	// reproducible regardless of the user's Go toolchain.
+	// simplification as a public Go API. diff and the go/printer used by the
+	// format package are vendored copies frozen at a specific Go version, so
+	// gofumpt's output is reproducible regardless of the user's Go toolchain.
 	gformat "mvdan.cc/gofumpt/format"
+	// NOTE(gofumpt): cache records which files are already formatted.
+	"mvdan.cc/gofumpt/internal/cache"
+	// NOTE(gofumpt): config and gomod find and cache each file's gofumpt.toml
+	// and go.mod, which are used to honor their ignore patterns;
+	// their other options are resolved via gformat.OptionsForFile.
+	"mvdan.cc/gofumpt/internal/config"
+	// NOTE(gofumpt): formatinternal parses and prints program fragments,
+	// and exposes the parts of the format package only used by the tool.
+	"mvdan.cc/gofumpt/internal/formatinternal"
+	"mvdan.cc/gofumpt/internal/gomod"
 	"mvdan.cc/gofumpt/internal/govendor/diff"
-	"mvdan.cc/gofumpt/internal/govendor/go/printer"
+	// NOTE(gofumpt): ignore implements .gofumptignore files and -exclude.
+	"mvdan.cc/gofumpt/internal/ignore"
+	// NOTE(gofumpt): lsp implements the -lsp mode for editors.
+	"mvdan.cc/gofumpt/internal/lsp"
 	gversion "mvdan.cc/gofumpt/internal/version"
 )
 
@@ -61,11 +71,46 @@ var (
 	// -modpath sets the current module path so import grouping can treat
 	// imports sharing that prefix as third-party; defaulted from go.mod.
 	// -extra opts in to non-default rules like group_params.
+	// -disable opts out of rules like short-decl, including default ones.
+	// -local groups imports under the given prefixes last, like goimports.
+	// -line-length sets the line length for -extra=split_long_lines.
+	// -check prints nothing, exiting with 1 if any files' formatting differs.
+	// -explain annotates each -d hunk with the rules which changed it.
+	// -diff-base only applies the rules to lines changed since a git revision.
+	// -staged formats the Go files staged in the git index, for pre-commit hooks.
+	// -exclude skips walked paths matching a gitignore pattern; it can be repeated.
+	// -lsp serves LSP formatting requests over stdio for editors.
+	// -stdin-filename gives the path of the file read from stdin.
+	// -format=json prints a JSON report per file instead of its source,
+	// and -format=sarif prints a single SARIF log for all files.
 	// -version prints the gofumpt build version (set via -ldflags=main.version=).
-	langVersion = flag.String("lang", "", "")
-	modulePath  = flag.String("modpath", "", "")
-	extraRules  gformat.Extra
-	showVersion = flag.Bool("version", false, "")
+	langVersion   = flag.String("lang", "", "")
+	modulePath    = flag.String("modpath", "", "")
+	extraRules    gformat.Extra
+	disableRules  gformat.Rules
+	lineLength    = flag.Int("line-length", 0, "")
+	localPrefix   = flag.String("local", "", "")
+	check         = flag.Bool("check", false, "")
+	explain       = flag.Bool("explain", false, "")
+	diffBase      = flag.String("diff-base", "", "")
+	staged        = flag.Bool("staged", false, "")
+	excludeFlags  stringList
+	lspMode       = flag.Bool("lsp", false, "")
+	stdinFilename = flag.String("stdin-filename", "", "")
+	outputFormat  = flag.String("format", "", "")
+	showVersion   = flag.Bool("version", false, "")
+
+	// NOTE(gofumpt): the names of the flags given on the command line,
+	// which take precedence over the options in gofumpt.toml files.
+	setFlags = make(map[string]bool)
+
+	// NOTE(gofumpt): the cache of formatted files, if enabled;
+	// see openCache.
+	fileCache *cache.Cache
+
+	// NOTE(gofumpt): the patterns from -exclude, relative to the current
+	// directory, which take precedence over .gofumptignore files.
+	excludePatterns []ignore.Pattern
 
 	// NOTE(gofumpt): -r and -s are kept only to print a friendly error.
 	// -r was dropped in favor of `gofmt -r`; -s is always on (gofumpt always
@@ -81,23 +126,28 @@ var (
 	errFormattingDiffers = fmt.Errorf("formatting differs from gofumpt's")
 )
 
-func init() { flag.Var(&extraRules, "extra", "") }
+func init() {
+	flag.Var(&extraRules, "extra", "")
+	flag.Var(&disableRules, "disable", "")
+	flag.Var(&excludeFlags, "exclude", "")
+}
+
+// stringList is a flag which can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
 
 // NOTE(gofumpt): set via -ldflags=main.version=... at release time so that
 // `gofumpt -version` reports a meaningful string for prebuilt binaries.
 var version = ""
 
-// Keep these in sync with go/format/format.go.
-const (
-	tabWidth    = 8
-	printerMode = printer.UseSpaces | printer.TabIndent | printerNormalizeNumbers
-
-	// printerNormalizeNumbers means to canonicalize number literal prefixes
-	// and exponents while printing. See https://golang.org/doc/go1.13#gofmt.
-	//
-	// This value is defined in go/printer specifically for go/format and cmd/gofmt.
-	printerNormalizeNumbers = 1 << 30
-)
+// NOTE(gofumpt): upstream gofmt declares its printer configuration here;
+// files are printed via formatinternal.ParsedFile.Print instead.
 
 // fdSem guards the number of concurrently-open file descriptors.
 //
@@ -129,15 +179,28 @@ func newFileSet() *token.FileSet {
 func usage() {
 	fmt.Fprintf(os.Stderr, `usage: gofumpt [flags] [path ...]
 	-version  show version and exit
+	-lsp      serve LSP formatting requests over stdin and stdout
 
 	-d        display diffs instead of rewriting files
 	-e        report all errors (not just the first 10 on different lines)
 	-l        list files whose formatting differs from gofumpt's
 	-w        write result to (source) file instead of stdout
+	-check    print nothing; exit with 1 if formatting differs, 2 on errors
+	-explain  annotate each -d hunk with the names of the rules which changed it
+	-staged   format the Go files staged in the git index; -w updates the index
 	-extra    enable extra rules, e.g. -extra=group_params,clothe_returns
-
-	-lang       str    target Go version in the form "go1.X" (default from go.mod)
-	-modpath    str    Go module path containing the source file (default from go.mod)
+	-disable  disable rules, e.g. -disable=short-decl,decl-group-many
+
+	-lang           str  target Go version in the form "go1.X" (default from go.mod)
+	-modpath        str  Go module path containing the source file (default from go.mod)
+	-local          str  comma-separated import path prefixes to group last
+	-line-length    int  line length for -extra=split_long_lines (default 100)
+	-stdin-filename str  path of the file read from stdin, to find its go.mod
+	-format         str  print reports with -format=json or -format=sarif
+	-diff-base      rev  only apply the added rules to lines changed since a git revision
+	-exclude        glob skip walked paths matching a gitignore pattern; can be repeated
+
+Defaults for the flags above are read from the nearest gofumpt.toml file.
 `)
 }
 
@@ -192,7 +255,7 @@ type sequencer struct {
 func newSequencer(maxWeight int64, out, err io.Writer) *sequencer {
 	sem := semaphore.NewWeighted(maxWeight)
 	prev := make(chan *reporterState, 1)
-	prev <- &reporterState{out: out, err: err}
+	prev <- &reporterState{out: out, err: err, sarif: &sarifLog{}}
 	return &sequencer{
 		maxWeight: maxWeight,
 		sem:       sem,
@@ -273,7 +336,13 @@ type reporter struct {
 // Only one reporter at a time may have access to a reporterState.
 type reporterState struct {
 	out, err io.Writer
-	exitCode int
+
+	// NOTE(gofumpt): each category of reported errors is tracked separately,
+	// so that the exit code is 2 for any errors even if later files differ.
+	differs bool // a file's formatting differs, for exit code 1
+	failed  bool // any other error, such as a syntax or IO error, for exit code 2
+
+	sarif *sarifLog // NOTE(gofumpt): collects all reports with -format=sarif
 }
 
 // getState blocks until any prior reporters are finished with the reporter
@@ -307,15 +376,26 @@ func (r *reporter) Report(err error) {
 	}
 	st := r.getState()
 	if err == errFormattingDiffers {
-		st.exitCode = 1
+		st.differs = true
+	} else if err == errReported {
+		st.failed = true
 	} else {
 		scanner.PrintError(st.err, err)
-		st.exitCode = 2
+		st.failed = true
 	}
 }
 
+// ExitCode returns 2 if any errors were reported,
+// 1 if any files' formatting differs, and 0 otherwise.
 func (r *reporter) ExitCode() int {
-	return r.getState().exitCode
+	st := r.getState()
+	switch {
+	case st.failed:
+		return 2
+	case st.differs:
+		return 1
+	}
+	return 0
 }
 
 // If info == nil, we are formatting stdin instead of a file.
@@ -325,70 +405,120 @@ func (r *reporter) ExitCode() int {
 // this file was named directly on the command line. Explicit files always get
 // the gofumpt rules applied (even generated files); walked files do not when
 // they look generated. It also forces non-.go explicit args to be formatted.
-func processFile(filename string, info fs.FileInfo, in io.Reader, r *reporter, explicit bool) error {
+func processFile(filename string, info fs.FileInfo, in io.Reader, r *reporter, explicit bool) (err error) {
+	// NOTE(gofumpt): with -format=json, a report is printed for each file
+	// instead of its formatted source, including any error processing it.
+	// With -format=sarif, the reports are printed together at the end.
+	var report *fileReport
+	if *outputFormat != "" {
+		report = &fileReport{Path: filename}
+		defer func() { err = report.write(r, err) }()
+	}
+
 	src, err := readFile(filename, info, in)
 	if err != nil {
 		return err
 	}
 
+	opts, err := optionsForFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// NOTE(gofumpt): skip files which are known to be formatted already.
+	// Program fragments from stdin are never cached.
+	var cacheKey *cache.Key
+	if fileCache != nil && info != nil {
+		key, err := fileCache.Key(src, struct {
+			Options  gformat.Options
+			Explicit bool
+		}{opts, explicit})
+		if err != nil {
+			return err
+		}
+		if fileCache.Formatted(key) {
+			if report != nil {
+				// Only the build constraint is needed for the language version.
+				file, err := parser.ParseFile(newFileSet(), filename, src, parser.PackageClauseOnly|parser.ParseComments)
+				if err != nil {
+					return err
+				}
+				report.setOptions(opts, file)
+			}
+			if !*list && !*write && !*doDiff && !*check && report == nil {
+				_, err = r.Write(src)
+			}
+			return err
+		}
+		cacheKey = &key
+	}
+
 	fileSet := newFileSet()
 	// If we are formatting stdin, we accept a program fragment in lieu of a
 	// complete source file.
-	fragmentOk := info == nil
-	file, sourceAdj, indentAdj, err := parse(fileSet, filename, src, fragmentOk)
+	// NOTE(gofumpt): parsing and printing program fragments is shared with
+	// the format package, so that its API supports them as well.
+	opts.AllowFragments = info == nil
+	parsed, err := formatinternal.Parse(fileSet, filename, src, parserMode, opts.AllowFragments)
 	if err != nil {
 		return err
 	}
+	file := parsed.File
+
+	// NOTE(gofumpt): with -diff-base, only apply gofumpt's rules to the lines
+	// changed since a git revision. Find them before anything moves lines.
+	var ranges []formatinternal.Range
+	if *diffBase != "" {
+		ranges, err = changedRanges(filename, fileSet.File(file.Pos()))
+		if err != nil {
+			return err
+		}
+	}
 
 	ast.SortImports(fileSet, file)
 
 	// NOTE(gofumpt): from here until the call to format() below is the
-	// gofumpt-specific work upstream gofmt does not do: resolve -lang and
-	// -modpath defaults from the file's containing go.mod, then run
-	// gformat.File to apply the added rules (and simplification, which
-	// gofumpt always runs in lieu of the dropped -s flag). Apply gofumpt's
+	// gofumpt-specific work upstream gofmt does not do: run gformat.File with
+	// the options resolved above from the flags, the file's gofumpt.toml,
+	// and its containing go.mod, to apply the added rules (and simplification,
+	// which gofumpt always runs in lieu of the dropped -s flag). Apply gofumpt's
 	// changes before we print the code in gofumpt's format.
 
-	// If either -lang or -modpath aren't set, fetch them from go.mod.
-	lang := *langVersion
-	modpath := *modulePath
-	if lang == "" || modpath == "" {
-		path, err := filepath.Abs(filename)
-		if err != nil {
-			return err
-		}
-		if mod := loadModule(filepath.Dir(path)); mod != nil {
-			if lang == "" {
-				if mod.file.Go == nil {
-					// If the go directive is missing, go 1.16 is assumed.
-					// https://go.dev/ref/mod#go-mod-file-go
-					lang = "go1.16"
-				} else {
-					lang = "go" + mod.file.Go.Version
-				}
-			}
-			if m := mod.file.Module; m != nil && modpath == "" {
-				modpath = m.Mod.Path
-			}
-		}
+	if report != nil {
+		report.setOptions(opts, file)
 	}
 
 	// We always apply the gofumpt formatting rules to explicit files, including stdin.
 	// Otherwise, we don't apply them on generated files.
 	// We also skip walking vendor directories entirely, but that happens elsewhere.
 	if explicit || !isGenerated(file) {
-		gformat.File(fileSet, file, gformat.Options{
-			LangVersion: lang,
-			ModulePath:  modpath,
-			Extra:       extraRules,
-		})
+		if ranges != nil {
+			formatinternal.FileRanges(fileSet, file, ranges, opts)
+		} else {
+			gformat.File(fileSet, file, opts)
+		}
 	}
 
-	res, err := format(fileSet, file, sourceAdj, indentAdj, src, printer.Config{Mode: printerMode, Tabwidth: tabWidth})
+	res, err := parsed.Print(fileSet)
 	if err != nil {
 		return err
 	}
 
+	if report != nil {
+		report.setResult(src, res)
+		// NOTE(gofumpt): name the rules behind each SARIF result, like -explain.
+		// Program fragments from stdin cannot be checked.
+		if report.Changed && *outputFormat == "sarif" && (explicit || !isGenerated(file)) {
+			if diags, err := gformat.Check(src, opts); err == nil {
+				report.setDiagnostics(diags)
+			}
+		}
+	}
+
+	if cacheKey != nil && bytes.Equal(src, res) {
+		fileCache.SetFormatted(*cacheKey) // best effort
+	}
+
 	if !bytes.Equal(src, res) {
 		// formatting has changed
 		if *list {
@@ -399,26 +529,110 @@ func processFile(filename string, info fs.FileInfo, in io.Reader, r *reporter, e
 				panic("-w should not have been allowed with stdin")
 			}
 
-			perm := info.Mode().Perm()
-			if err := writeFile(filename, src, res, perm, info.Size()); err != nil {
-				return err
+			// NOTE(gofumpt): with -staged, the source is a blob in the git
+			// index, so the formatted result is staged in its place.
+			if st, ok := info.(*stagedInfo); ok {
+				if err := st.write(src, res); err != nil {
+					return err
+				}
+			} else {
+				perm := info.Mode().Perm()
+				if err := writeFile(filename, src, res, perm, info.Size()); err != nil {
+					return err
+				}
 			}
 		}
 		if *doDiff {
 			newName := filepath.ToSlash(filename)
 			oldName := newName + ".orig"
-			r.Write(diff.Diff(oldName, src, newName, res))
+			d := diff.Diff(oldName, src, newName, res)
+			if *explain && (explicit || !isGenerated(file)) {
+				// NOTE(gofumpt): annotate each hunk with the rules which
+				// changed it, as diagnosed on the original source.
+				// Program fragments from stdin cannot be checked.
+				if diags, err := gformat.Check(src, opts); err == nil {
+					d = explainDiff(d, diags)
+				}
+			}
+			r.Write(d)
+			return errFormattingDiffers
+		}
+	}
+
+	// NOTE(gofumpt): -check only reports whether the formatting differs.
+	if *check {
+		if !bytes.Equal(src, res) {
 			return errFormattingDiffers
 		}
+		return nil
 	}
 
-	if !*list && !*write && !*doDiff {
+	if !*list && !*write && !*doDiff && report == nil {
 		_, err = r.Write(res)
 	}
 
 	return err
 }
 
+// optionsForFile returns the options to format the Go file at filename.
+// Flags take precedence over gofumpt.toml, which takes precedence over go.mod.
+func optionsForFile(filename string) (gformat.Options, error) {
+	// The go.mod and gofumpt.toml files are read one at a time.
+	fdSem <- true
+	opts, err := gformat.OptionsForFile(filename)
+	<-fdSem
+	if err != nil {
+		return gformat.Options{}, err
+	}
+	if *langVersion != "" {
+		opts.LangVersion = *langVersion
+	}
+	if *modulePath != "" {
+		opts.ModulePath = *modulePath
+	}
+	if setFlags["extra"] {
+		opts.Extra = extraRules
+	}
+	if setFlags["disable"] {
+		opts.Disable = disableRules
+	}
+	if setFlags["line-length"] {
+		opts.LineLength = *lineLength
+	}
+	if setFlags["local"] {
+		opts.LocalPrefixes = nil
+		if *localPrefix != "" {
+			opts.LocalPrefixes = strings.Split(*localPrefix, ",")
+		}
+	}
+	return opts, nil
+}
+
+// openCache opens the cache of formatted files,
+// returning nil if it is disabled or its directory is not available.
+// Development builds cannot be told apart by their version,
+// so their cache entries are specific to their executable.
+func openCache() (*cache.Cache, error) {
+	dir, err := cache.DefaultDir()
+	if err != nil || dir == "" {
+		return nil, err
+	}
+	c, err := cache.Open(dir)
+	if err != nil {
+		return nil, nil // e.g. a read-only home directory
+	}
+	c.Salt = []byte(gversion.String(version))
+	if !gversion.IsRelease(version) {
+		hash, err := cache.ExecutableHash()
+		if err != nil {
+			return nil, nil
+		}
+		c.Salt = append(c.Salt, hash...)
+	}
+	c.Trim() // best effort
+	return c, nil
+}
+
 // readFile reads the contents of filename, described by info.
 // If in is non-nil, readFile reads directly from it.
 // Otherwise, readFile opens and reads the file itself,
@@ -502,6 +716,7 @@ func main() {
 func gofmtMain(s *sequencer) {
 	flag.Usage = usage
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
 
 	// NOTE(gofumpt): friendly handling of the dropped -s and -r flags so users
 	// migrating from gofmt get a clear message rather than "flag provided but
@@ -514,6 +729,46 @@ func gofmtMain(s *sequencer) {
 		os.Exit(2)
 	}
 
+	switch *outputFormat {
+	case "":
+	case "json", "sarif":
+		if *list || *doDiff {
+			fmt.Fprintf(os.Stderr, "cannot use -format=%s with -l or -d\n", *outputFormat)
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -format: %q\n", *outputFormat)
+		os.Exit(2)
+	}
+
+	if *explain && !*doDiff {
+		fmt.Fprintf(os.Stderr, "cannot use -explain without -d\n")
+		os.Exit(2)
+	}
+
+	if *check && *write {
+		fmt.Fprintf(os.Stderr, "cannot use -check with -w\n")
+		os.Exit(2)
+	}
+
+	if *lineLength < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -line-length: %d\n", *lineLength)
+		os.Exit(2)
+	}
+
+	if len(excludeFlags) > 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			s.AddReport(err)
+			return
+		}
+		for _, line := range excludeFlags {
+			if p, ok := ignore.Parse(wd, line); ok {
+				excludePatterns = append(excludePatterns, p)
+			}
+		}
+	}
+
 	// NOTE(gofumpt): print the gofumpt version if the user asks for it.
 	// -version dumps the build version and any embedded build-info fields
 	// (see internal/version), useful for bug reports and `//gofumpt:diagnose`.
@@ -539,18 +794,99 @@ func gofmtMain(s *sequencer) {
 
 	initParserMode()
 
+	// NOTE(gofumpt): the SARIF log is printed once all files are processed.
+	if *outputFormat == "sarif" {
+		defer s.Add(0, func(r *reporter) error {
+			return r.getState().sarif.write(r)
+		})
+	}
+
 	args := flag.Args()
+
+	// NOTE(gofumpt): -lsp replaces the normal operation modes with a
+	// language server, resolving each document's options from its path,
+	// and leaving alone the documents which walking would skip.
+	if *lspMode {
+		if len(args) > 0 || *list || *write || *doDiff || *outputFormat != "" {
+			s.AddReport(fmt.Errorf("error: cannot use -lsp with paths or the -l, -w, -d, or -format flags"))
+			return
+		}
+		if err := lsp.Serve(os.Stdin, os.Stdout, optionsForFile, shouldIgnoreFile); err != nil {
+			s.AddReport(err)
+		}
+		return
+	}
+
+	// NOTE(gofumpt): the cache is only used when formatting files,
+	// and not with -diff-base, as its ranges depend on the git history.
+	if *diffBase == "" && (len(args) > 0 || *staged) {
+		var err error
+		if fileCache, err = openCache(); err != nil {
+			s.AddReport(err)
+			return
+		}
+	}
+
+	// NOTE(gofumpt): -staged formats the contents of the Go files staged in
+	// the git index rather than the work tree, like walked files.
+	// Any paths limit which staged files are formatted.
+	if *staged {
+		if *diffBase != "" || *stdinFilename != "" {
+			s.AddReport(fmt.Errorf("error: cannot use -staged with -diff-base or -stdin-filename"))
+			return
+		}
+		files, err := stagedFiles(args)
+		if err != nil {
+			s.AddReport(err)
+			return
+		}
+		for _, file := range files {
+			s.Add(file.size, func(r *reporter) error {
+				src, err := file.read()
+				if err != nil {
+					return err
+				}
+				return processFile(file.path, file, bytes.NewReader(src), r, false)
+			})
+		}
+		return
+	}
 	if len(args) == 0 {
 		if *write {
 			s.AddReport(fmt.Errorf("error: cannot use -w with standard input"))
 			return
 		}
+		if *diffBase != "" {
+			s.AddReport(fmt.Errorf("error: cannot use -diff-base with standard input"))
+			return
+		}
+		// NOTE(gofumpt): with -stdin-filename, stdin is treated like a walked
+		// file at the given path, so that its module, generated-file handling,
+		// and ignore patterns apply. Ignored files are copied as they are.
+		if *stdinFilename != "" {
+			filename := filepath.Clean(*stdinFilename)
+			s.Add(0, func(r *reporter) error {
+				if shouldIgnoreFile(filename) {
+					if *list || *doDiff || *check || *outputFormat != "" {
+						return nil
+					}
+					_, err := io.Copy(r, os.Stdin)
+					return err
+				}
+				return processFile(filename, nil, os.Stdin, r, false)
+			})
+			return
+		}
 		s.Add(0, func(r *reporter) error {
 			// TODO: test explicit==true
 			return processFile("<standard input>", nil, os.Stdin, r, true)
 		})
 		return
 	}
+	if *stdinFilename != "" {
+		s.AddReport(fmt.Errorf("error: cannot use -stdin-filename with paths"))
+		return
+	}
 
 	// NOTE(gofumpt): the argument-walking loop below is rewritten vs upstream.
 	// Upstream branched on os.Stat (file vs dir); gofumpt always uses
@@ -579,6 +915,8 @@ func gofmtMain(s *sequencer) {
 				// non-directories given as explicit arguments are always formatted
 			case !isGoFilename(d.Name()):
 				return nil // skip walked non-Go files
+			case excluded(path, false):
+				return nil
 			}
 			info, err := d.Info()
 			if err != nil {
@@ -596,8 +934,9 @@ func gofmtMain(s *sequencer) {
 
 // NOTE(gofumpt): everything from here to the end of the file is gofumpt-only.
 // shouldIgnore implements skipping `vendor` and `testdata` directories during
-// walks, plus honoring Go 1.25's `ignore` directives in go.mod. These are
-// skipped during recursive walks but still formatted when named explicitly.
+// walks, plus honoring Go 1.25's `ignore` directives in go.mod and the ignore
+// patterns in gofumpt.toml. These are skipped during recursive walks but still
+// formatted when named explicitly.
 func shouldIgnore(path string) bool {
 	switch filepath.Base(path) {
 	case "vendor", "testdata":
@@ -607,19 +946,82 @@ func shouldIgnore(path string) bool {
 	if err != nil {
 		return false // unclear how this could happen; don't ignore in any case
 	}
-	mod := loadModule(path)
-	if mod == nil {
-		return false // no module file to declare ignore paths
+	fdSem <- true
+	mod := gomod.Load(path)
+	// An invalid config file is reported when formatting the files using it.
+	cfg, _ := config.Load(path)
+	<-fdSem
+	if mod != nil {
+		for _, ignore := range mod.File.Ignore {
+			if matchIgnoreIn(mod.Dir, ignore.Path, path) {
+				return true
+			}
+		}
+	}
+	if cfg != nil {
+		for _, ignore := range cfg.Ignore {
+			if matchIgnoreIn(cfg.Dir, ignore, path) {
+				return true
+			}
+		}
+	}
+	return excluded(path, true)
+}
+
+// excluded reports whether a path is excluded by the .gofumptignore files
+// in its parent directories or by the -exclude flags, in that order,
+// where the last pattern which matches the path takes precedence.
+func excluded(path string, isDir bool) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false // unclear how this could happen; don't ignore in any case
+	}
+	fdSem <- true
+	patterns := ignore.Load(filepath.Dir(path))
+	<-fdSem
+	if len(excludePatterns) > 0 {
+		patterns = append(slices.Clip(patterns), excludePatterns...)
+	}
+	return ignore.Match(patterns, path, isDir)
+}
+
+// matchIgnoreIn reports whether the ignore pattern, relative to dir,
+// matches the absolute directory path.
+func matchIgnoreIn(dir, ignore, path string) bool {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false // unclear how this could happen; don't ignore in any case
 	}
-	relPath, err := filepath.Rel(mod.absDir, path)
+	return matchIgnore(ignore, normalizePath(relPath))
+}
+
+// shouldIgnoreFile reports whether the file at path would be skipped when
+// walking its module, or its directory if it's not in a module,
+// as it is [excluded] or one of its parent directories is ignored per [shouldIgnore].
+func shouldIgnoreFile(path string) bool {
+	path, err := filepath.Abs(path)
 	if err != nil {
 		return false // unclear how this could happen; don't ignore in any case
 	}
-	relPath = normalizePath(relPath)
-	for _, ignore := range mod.file.Ignore {
-		if matchIgnore(ignore.Path, relPath) {
+	if excluded(path, false) {
+		return true
+	}
+	var root string
+	fdSem <- true
+	mod := gomod.Load(filepath.Dir(path))
+	<-fdSem
+	if mod != nil {
+		root = mod.Dir
+	}
+	for dir := filepath.Dir(path); dir != root; {
+		if shouldIgnore(dir) {
 			return true
 		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break // reached the filesystem root
+		}
+		dir = parent
 	}
 	return false
 }
@@ -648,63 +1050,6 @@ func matchIgnore(ignore, relPath string) bool {
 	return strings.HasSuffix(relPath, ignore)
 }
 
-// NOTE(gofumpt): module loading is gofumpt-only. The go.mod is consulted for
-// the default -lang (Go language version, used by version-gated rules), the
-// default -modpath (so imports sharing the module prefix are grouped as
-// third-party), and the `ignore` directives consumed by shouldIgnore above.
-// Results are cached per directory; loadModule walks up to find an enclosing
-// go.mod just like the go command would.
-//
-// A nil entry means the directory is not part of a Go module,
-// or a go.mod file was found but it's invalid.
-// A non-nil entry means this directory, or a parent, is in a valid Go module.
-var cachedModuleByDir sync.Map // map[dirString]*cachedModfile
-
-type cachedModule struct {
-	absDir string // the directory where the go.mod file was found
-	file   *modfile.File
-}
-
-func loadModule(dir string) *cachedModule {
-	if cached, ok := cachedModuleByDir.Load(dir); ok {
-		mf, _ := cached.(*cachedModule)
-		return mf
-	}
-	mod := func() *cachedModule {
-		path := filepath.Join(dir, "go.mod")
-		fdSem <- true
-		data, err := os.ReadFile(path)
-		<-fdSem
-		if err != nil {
-			// If the file is missing, or we can't read this directory at all
-			// (e.g. permission denied on a directory listed in `ignore`), keep
-			// walking up to find an enclosing go.mod.
-			parent := filepath.Dir(dir)
-			if parent == "." {
-				panic("loadModule was not given an absolute path?")
-			}
-			if parent == dir {
-				return nil // reached the filesystem root
-			}
-			return loadModule(parent) // try the parent directory
-		}
-		file, err := modfile.Parse(filepath.Join(dir, "go.mod"), data, nil)
-		if err != nil {
-			return nil // invalid go.mod file
-		}
-		return &cachedModule{
-			absDir: dir,
-			file:   file,
-		}
-	}()
-	if mod != nil {
-		cachedModuleByDir.Store(dir, mod)
-	} else {
-		cachedModuleByDir.Store(dir, nil)
-	}
-	return mod
-}
-
 func fileWeight(path string, info fs.FileInfo) int64 {
 	if info == nil {
 		return exclusive
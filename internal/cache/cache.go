@@ -0,0 +1,187 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package cache implements an on-disk cache of the Go files which are known
+// to be formatted, keyed by their contents and the options used to format them,
+// so that gofumpt can skip parsing and printing them again.
+//
+// Each entry is an empty file named after its key, so the cache can be
+// safely used by many goroutines and processes at once.
+// Like GOCACHE, entries which have not been used for a few days are removed
+// by [Cache.Trim], so the cache does not grow forever.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvVar is the environment variable which sets the cache directory,
+// or disables the cache when set to "off".
+const EnvVar = "GOFUMPTCACHE"
+
+// DefaultDir returns the directory to use for the cache,
+// or an empty string if the cache is disabled.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv(EnvVar); dir == "off" {
+		return "", nil
+	} else if dir != "" {
+		if !filepath.IsAbs(dir) {
+			return "", errors.New(EnvVar + " is not an absolute path")
+		}
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", nil // e.g. $HOME is not set
+	}
+	return filepath.Join(dir, "gofumpt"), nil
+}
+
+// A Cache records which inputs are known to be formatted.
+type Cache struct {
+	dir string
+
+	// Salt is included in every key, and it must identify the version of
+	// gofumpt being used, as newer versions may format the same input differently.
+	Salt []byte
+}
+
+// A Key identifies the input to format a file.
+type Key [sha256.Size]byte
+
+// Open opens the cache in dir, creating it if needed.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, err
+	}
+	// Like GOCACHE, explain what this directory is to anyone who finds it.
+	readme := filepath.Join(dir, "README")
+	if _, err := os.Stat(readme); errors.Is(err, os.ErrNotExist) {
+		const text = "This directory holds cached data from gofumpt.\n" +
+			"It can be safely removed at any time.\n"
+		if err := os.WriteFile(readme, []byte(text), 0o666); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Key returns the key for formatting src with the given options,
+// which are encoded as JSON.
+func (c *Cache) Key(src []byte, opts any) (Key, error) {
+	h := sha256.New()
+	h.Write(c.Salt)
+	h.Write([]byte{0})
+	if err := json.NewEncoder(h).Encode(opts); err != nil {
+		return Key{}, err
+	}
+	h.Write(src)
+	var key Key
+	h.Sum(key[:0])
+	return key, nil
+}
+
+func (c *Cache) path(key Key) string {
+	name := hex.EncodeToString(key[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// mtimeInterval is how often the modification time of used entries is updated,
+// so that the entries which are no longer used can be found and removed by Trim.
+const mtimeInterval = 24 * time.Hour
+
+// trimInterval is how often the cache is trimmed, and trimLimit is how long
+// an entry must have been unused for to be removed. trimLimit must be well
+// above mtimeInterval, as the modification times of entries are not precise.
+const (
+	trimInterval = 24 * time.Hour
+	trimLimit    = 5 * 24 * time.Hour
+)
+
+// Formatted reports whether the input for key is known to be formatted.
+func (c *Cache) Formatted(key Key) bool {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if now := time.Now(); now.Sub(info.ModTime()) > mtimeInterval {
+		os.Chtimes(path, now, now) // best effort
+	}
+	return true
+}
+
+// SetFormatted records that the input for key is formatted.
+func (c *Cache) SetFormatted(key Key) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	// The entry holds no data, so concurrent writers cannot corrupt it.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Trim removes the entries which have not been used for a few days.
+// The cache records when it was last trimmed, so Trim does nothing if it was
+// trimmed recently, which makes it cheap to call each time the cache is used.
+func (c *Cache) Trim() error {
+	now := time.Now()
+	trimPath := filepath.Join(c.dir, "trim.txt")
+	if data, err := os.ReadFile(trimPath); err == nil {
+		if unix, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil &&
+			now.Sub(time.Unix(unix, 0)) < trimInterval {
+			return nil
+		}
+	}
+	// Record the time first, so that other processes are unlikely to trim too.
+	if err := os.WriteFile(trimPath, fmt.Appendf(nil, "%d\n", now.Unix()), 0o666); err != nil {
+		return err
+	}
+	cutoff := now.Add(-trimLimit)
+	for i := range 256 {
+		subdir := filepath.Join(c.dir, fmt.Sprintf("%02x", i))
+		entries, err := os.ReadDir(subdir)
+		if err != nil {
+			continue // no entries were ever added
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(filepath.Join(subdir, entry.Name())) // best effort
+			}
+		}
+	}
+	return nil
+}
+
+// ExecutableHash returns a hash of the running executable,
+// which can be used as a salt for development builds.
+func ExecutableHash() ([]byte, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
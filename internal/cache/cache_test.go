@@ -0,0 +1,49 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestTrim(t *testing.T) {
+	t.Parallel()
+
+	c, err := Open(t.TempDir())
+	qt.Assert(t, qt.IsNil(err))
+
+	used, err := c.Key([]byte("used"), nil)
+	qt.Assert(t, qt.IsNil(err))
+	unused, err := c.Key([]byte("unused"), nil)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(c.SetFormatted(used)))
+	qt.Assert(t, qt.IsNil(c.SetFormatted(unused)))
+
+	// Both entries were last used before the trim limit,
+	// but one of them is used again, updating its modification time.
+	old := time.Now().Add(-trimLimit - time.Hour)
+	qt.Assert(t, qt.IsNil(os.Chtimes(c.path(used), old, old)))
+	qt.Assert(t, qt.IsNil(os.Chtimes(c.path(unused), old, old)))
+	qt.Assert(t, qt.IsTrue(c.Formatted(used)))
+
+	qt.Assert(t, qt.IsNil(c.Trim()))
+	qt.Assert(t, qt.IsTrue(c.Formatted(used)))
+	qt.Assert(t, qt.IsFalse(c.Formatted(unused)))
+
+	// The cache was trimmed recently, so it is not trimmed again.
+	qt.Assert(t, qt.IsNil(c.SetFormatted(unused)))
+	qt.Assert(t, qt.IsNil(os.Chtimes(c.path(unused), old, old)))
+	qt.Assert(t, qt.IsNil(c.Trim()))
+	_, err = os.Stat(c.path(unused))
+	qt.Assert(t, qt.IsNil(err))
+
+	// The README is never removed.
+	_, err = os.Stat(filepath.Join(c.dir, "README"))
+	qt.Assert(t, qt.IsNil(err))
+}
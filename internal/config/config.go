@@ -0,0 +1,106 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package config finds and caches the gofumpt.toml files which configure
+// gofumpt for the source files in a directory and its subdirectories,
+// shared by the gofumpt tool and the format package.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+
+	"mvdan.cc/gofumpt/internal/gomod"
+)
+
+// FileName is the name of the configuration file,
+// which is usually placed next to a go.mod file.
+const FileName = "gofumpt.toml"
+
+// cachedConfigByDir holds a *loaded entry per directory.
+var cachedConfigByDir sync.Map // map[dirString]*loaded
+
+type loaded struct {
+	cfg *Config
+	err error
+}
+
+// Config is a parsed gofumpt.toml file. Its keys match the gofumpt flags:
+//
+//	lang = "go1.22"
+//	modpath = "example.com/foo"
+//	extra = ["group_params"]
+//	disable = ["short-decl"]
+//	line-length = 120
+//	local = ["example.com/org/"]
+//	ignore = ["./generated", "testdata_extra"]
+//
+// The ignore patterns are matched like the `ignore` directives in go.mod,
+// relative to the directory where the config file was found.
+type Config struct {
+	Dir string `toml:"-"` // the absolute directory where the config file was found
+
+	Lang       string   `toml:"lang"`
+	ModulePath string   `toml:"modpath"`
+	Extra      []string `toml:"extra"`
+	Disable    []string `toml:"disable"`
+	LineLength int      `toml:"line-length"`
+	Local      []string `toml:"local"`
+	Ignore     []string `toml:"ignore"`
+}
+
+// Load returns the config file for the absolute directory dir,
+// walking up the parent directories to find a gofumpt.toml file just like
+// go.mod files are found. The search stops at the root of the module
+// containing dir, so that a config file outside the module is never used.
+// Results are cached per directory, and Load is safe for concurrent use.
+//
+// Load returns nil and no error if no config file was found.
+// An error is returned if the config file that was found is invalid,
+// such as when it contains unknown keys.
+func Load(dir string) (*Config, error) {
+	if cached, ok := cachedConfigByDir.Load(dir); ok {
+		l := cached.(*loaded)
+		return l.cfg, l.err
+	}
+	cfg, err := func() (*Config, error) {
+		path := filepath.Join(dir, FileName)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			if mod := gomod.Load(dir); mod != nil && mod.Dir == dir {
+				return nil, nil // reached the module root
+			}
+			parent := filepath.Dir(dir)
+			if parent == "." {
+				panic("config.Load was not given an absolute path?")
+			}
+			if parent == dir {
+				return nil, nil // reached the filesystem root
+			}
+			return Load(parent) // try the parent directory
+		}
+		if err != nil {
+			return nil, err
+		}
+		cfg := &Config{Dir: dir}
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+		}
+		if cfg.LineLength < 0 {
+			return nil, fmt.Errorf("%s: invalid line-length: %d", path, cfg.LineLength)
+		}
+		return cfg, nil
+	}()
+	cachedConfigByDir.Store(dir, &loaded{cfg, err})
+	return cfg, err
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package formatinternal exposes parts of the format package to the gofumpt
+// tool, without making them part of the format package's API.
+//
+// Like x/tools' packagesinternal, the functions which need the format
+// package's internals are variables which the format package sets on init,
+// taking its options as an untyped parameter to avoid an import cycle.
+package formatinternal
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	goversion "go/version"
+
+	"mvdan.cc/gofumpt/internal/govendor/go/printer"
+)
+
+// Keep these in sync with go/format/format.go.
+const (
+	tabWidth    = 8
+	printerMode = printer.UseSpaces | printer.TabIndent | printerNormalizeNumbers
+
+	// printerNormalizeNumbers means to canonicalize number literal prefixes
+	// and exponents while printing. See https://golang.org/doc/go1.13#gofmt.
+	//
+	// This value is defined in go/printer specifically for go/format and cmd/gofmt.
+	printerNormalizeNumbers = 1 << 30
+)
+
+// Config is the printer configuration used by gofmt.
+var Config = printer.Config{Mode: printerMode, Tabwidth: tabWidth}
+
+// A ParsedFile is a Go source file or program fragment parsed by [Parse].
+type ParsedFile struct {
+	// File is the parsed syntax tree. A program fragment is wrapped in a
+	// package clause, and a list of statements is also wrapped in a function.
+	File *ast.File
+
+	src       []byte
+	sourceAdj func(src []byte, indent int) []byte
+	indentAdj int
+}
+
+// Parse parses src, which was read from the named file, as a Go source file,
+// or as a program fragment if fragmentOk is set.
+// The parser mode is used in addition to [parser.ParseComments].
+//
+// The parsed file can then be formatted before calling [ParsedFile.Print],
+// which is how both format.Source and the gofumpt tool format each file.
+func Parse(fset *token.FileSet, filename string, src []byte, mode parser.Mode, fragmentOk bool) (*ParsedFile, error) {
+	file, sourceAdj, indentAdj, err := parse(fset, filename, src, mode|parser.ParseComments, fragmentOk)
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedFile{File: file, src: src, sourceAdj: sourceAdj, indentAdj: indentAdj}, nil
+}
+
+// Print prints the parsed file in gofmt's format. A program fragment is printed
+// without the code wrapping it, keeping the indentation of the original source.
+func (p *ParsedFile) Print(fset *token.FileSet) ([]byte, error) {
+	return format(fset, p.File, p.sourceAdj, p.indentAdj, p.src, Config)
+}
+
+// Fragment reports whether the parsed file is a program fragment.
+func (p *ParsedFile) Fragment() bool {
+	return p.sourceAdj != nil
+}
+
+// Offset returns the byte offset in the parsed file at which the source starts,
+// which is only non-zero for a program fragment, as it is wrapped in a package
+// clause and perhaps a function on the same line as its first byte.
+func (p *ParsedFile) Offset() int {
+	switch {
+	case p.sourceAdj == nil:
+		return 0
+	case p.indentAdj == 0:
+		return len("package p;")
+	default:
+		return len("package p; func _() {")
+	}
+}
+
+// A Range is a range of source positions in a file, from Pos to End.
+type Range struct {
+	Pos, End token.Pos
+}
+
+// FileRanges is like format.File, but it only applies gofumpt's rules to the
+// syntax nodes which intersect any of the given ranges, like format.SourceRange
+// does. If ranges is empty, none of gofumpt's rules are applied,
+// although the file is still simplified as gofmt -s would.
+//
+// The options must be a format.Options value.
+var FileRanges func(fset *token.FileSet, file *ast.File, ranges []Range, opts any)
+
+// FileLangVersion returns the Go version which file is formatted with,
+// which is the given language version unless the file's `//go:build`
+// constraint sets a version. Like go/types, the version from the constraint is
+// used even if it is older, but never below go1.21, as Go toolchains before
+// go1.21 did not support per-file versions.
+func FileLangVersion(file *ast.File, lang string) string {
+	if fileLang := goversion.Lang(file.GoVersion); fileLang != "" {
+		if goversion.Compare(fileLang, "go1.21") < 0 {
+			return "go1.21"
+		}
+		return fileLang
+	}
+	return lang
+}
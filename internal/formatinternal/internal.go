@@ -2,12 +2,16 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// TODO(gri): This file and the file src/go/format/internal.go are
+// TODO(gri): This file and the file src/cmd/gofmt/internal.go are
 // the same (but for this comment and the package name). Do not modify
 // one without the other. Determine if we can factor out functionality
 // in a public API. See also #11844 for context.
 
-package main
+// NOTE(gofumpt): moved from cmd/gofmt so that the format package can accept
+// program fragments via Options.AllowFragments, and so that the gofumpt tool
+// can share this code via Parse when reading from standard input.
+
+package formatinternal
 
 import (
 	"bytes"
@@ -16,22 +20,22 @@ import (
 	"go/token"
 	"strings"
 
-	// NOTE(gofumpt): use a vendored copy of go/printer (and go/doc/comment)
-	// frozen at a specific Go version. This way installing a given gofumpt
-	// release produces the same output regardless of the user's Go toolchain.
 	"mvdan.cc/gofumpt/internal/govendor/go/printer"
 )
 
 // parse parses src, which was read from the named file,
 // as a Go source file, declaration, or statement list.
-func parse(fset *token.FileSet, filename string, src []byte, fragmentOk bool) (
+//
+// NOTE(gofumpt): the parser mode is a parameter rather than a global,
+// as the gofumpt tool sets parser.AllErrors via its -e flag.
+func parse(fset *token.FileSet, filename string, src []byte, mode parser.Mode, fragmentOk bool) (
 	file *ast.File,
 	sourceAdj func(src []byte, indent int) []byte,
 	indentAdj int,
 	err error,
 ) {
 	// Try as whole source file.
-	file, err = parser.ParseFile(fset, filename, src, parserMode)
+	file, err = parser.ParseFile(fset, filename, src, mode)
 	// If there's no error, return. If the error is that the source file didn't begin with a
 	// package line and source fragments are ok, fall through to
 	// try as a source fragment. Stop and return on any other error.
@@ -44,7 +48,7 @@ func parse(fset *token.FileSet, filename string, src []byte, fragmentOk bool) (
 	// Insert using a ';', not a newline, so that the line numbers
 	// in psrc match the ones in src.
 	psrc := append([]byte("package p;"), src...)
-	file, err = parser.ParseFile(fset, filename, psrc, parserMode)
+	file, err = parser.ParseFile(fset, filename, psrc, mode)
 	if err == nil {
 		sourceAdj = func(src []byte, indent int) []byte {
 			// Remove the package clause.
@@ -68,7 +72,7 @@ func parse(fset *token.FileSet, filename string, src []byte, fragmentOk bool) (
 	// in fsrc match the ones in src. Add an extra '\n' before the '}'
 	// to make sure comments are flushed before the '}'.
 	fsrc := append(append([]byte("package p; func _() {"), src...), '\n', '\n', '}')
-	file, err = parser.ParseFile(fset, filename, fsrc, parserMode)
+	file, err = parser.ParseFile(fset, filename, fsrc, mode)
 	if err == nil {
 		sourceAdj = func(src []byte, indent int) []byte {
 			// Cap adjusted indent to zero.
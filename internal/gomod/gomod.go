@@ -0,0 +1,89 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package gomod finds and caches the go.mod files which contain source files,
+// shared by the gofumpt tool and the format package.
+package gomod
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+)
+
+// A nil entry means the directory is not part of a Go module,
+// or a go.mod file was found but it's invalid.
+// A non-nil entry means this directory, or a parent, is in a valid Go module.
+var cachedModuleByDir sync.Map // map[dirString]*Module
+
+// Module is a parsed go.mod file.
+type Module struct {
+	Dir  string // the absolute directory where the go.mod file was found
+	File *modfile.File
+}
+
+// Load returns the module containing the absolute directory dir,
+// walking up the parent directories to find a go.mod file just like the
+// go command would. Results are cached per directory, and Load is safe for
+// concurrent use.
+//
+// Load returns nil if dir is not part of a Go module,
+// or if the go.mod file that was found is invalid.
+func Load(dir string) *Module {
+	if cached, ok := cachedModuleByDir.Load(dir); ok {
+		mf, _ := cached.(*Module)
+		return mf
+	}
+	mod := func() *Module {
+		path := filepath.Join(dir, "go.mod")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// If the file is missing, or we can't read this directory at all
+			// (e.g. permission denied on a directory listed in `ignore`), keep
+			// walking up to find an enclosing go.mod.
+			parent := filepath.Dir(dir)
+			if parent == "." {
+				panic("gomod.Load was not given an absolute path?")
+			}
+			if parent == dir {
+				return nil // reached the filesystem root
+			}
+			return Load(parent) // try the parent directory
+		}
+		file, err := modfile.Parse(path, data, nil)
+		if err != nil {
+			return nil // invalid go.mod file
+		}
+		return &Module{
+			Dir:  dir,
+			File: file,
+		}
+	}()
+	if mod != nil {
+		cachedModuleByDir.Store(dir, mod)
+	} else {
+		cachedModuleByDir.Store(dir, nil)
+	}
+	return mod
+}
+
+// LangVersion returns the Go language version declared by the module,
+// such as "go1.21.2". If the go directive is missing, go 1.16 is assumed.
+// See https://go.dev/ref/mod#go-mod-file-go.
+func (m *Module) LangVersion() string {
+	if m.File.Go == nil {
+		return "go1.16"
+	}
+	return "go" + m.File.Go.Version
+}
+
+// Path returns the module path, or an empty string if the module directive
+// is missing.
+func (m *Module) Path() string {
+	if m.File.Module == nil {
+		return ""
+	}
+	return m.File.Module.Mod.Path
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// NOTE(gofumpt): this file is not vendored from Go; it is kept by
+// gen_govendor.go when re-vendoring, and exposes the line-level hunks
+// computed by the anchored diff algorithm for gofumpt's own use,
+// as well as the byte-level edits built from them.
+
+package diff
+
+import "strings"
+
+// A Hunk is a run of lines which differ between two texts, without any
+// surrounding context lines. The zero-indexed, half-open line range
+// OldStart:OldEnd of the old text was replaced with NewStart:NewEnd
+// of the new text. Either range may be empty.
+type Hunk struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+}
+
+// Hunks returns the hunks in the anchored diff of old and new,
+// as computed by [Diff]. If old and new are identical, Hunks returns nil.
+func Hunks(old, new []byte) []Hunk {
+	x := Lines(old)
+	y := Lines(new)
+
+	var hunks []Hunk
+	var done pair
+	for _, m := range tgs(x, y) {
+		if m.x < done.x {
+			// Already handled scanning forward from earlier match.
+			continue
+		}
+		start := m
+		for start.x > done.x && start.y > done.y && x[start.x-1] == y[start.y-1] {
+			start.x--
+			start.y--
+		}
+		end := m
+		for end.x < len(x) && end.y < len(y) && x[end.x] == y[end.y] {
+			end.x++
+			end.y++
+		}
+		if start.x > done.x || start.y > done.y {
+			hunks = append(hunks, Hunk{
+				OldStart: done.x, OldEnd: start.x,
+				NewStart: done.y, NewEnd: start.y,
+			})
+		}
+		done = end
+	}
+	return hunks
+}
+
+// Lines returns the lines in the text x, including newlines.
+// Unlike the lines func used by [Diff], the last line is left as is
+// if it does not end in a newline, so that the lines always add up to x.
+func Lines(x []byte) []string {
+	l := strings.SplitAfter(string(x), "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+// An Edit replaces the bytes from Start to End in the old text with New.
+type Edit struct {
+	Start, End int
+	New        string
+}
+
+// Edits returns a minimal list of edits which turn old into new, starting from
+// the line-level [Hunks], and then trimming the bytes which each hunk has in
+// common at its start and end. If old and new are identical, Edits returns nil.
+func Edits(old, new []byte) []Edit {
+	oldOffsets := lineOffsets(old)
+	newOffsets := lineOffsets(new)
+	var edits []Edit
+	for _, h := range Hunks(old, new) {
+		oldStart, oldEnd := oldOffsets[h.OldStart], oldOffsets[h.OldEnd]
+		newStart, newEnd := newOffsets[h.NewStart], newOffsets[h.NewEnd]
+		for oldStart < oldEnd && newStart < newEnd && old[oldStart] == new[newStart] {
+			oldStart++
+			newStart++
+		}
+		for oldStart < oldEnd && newStart < newEnd && old[oldEnd-1] == new[newEnd-1] {
+			oldEnd--
+			newEnd--
+		}
+		edits = append(edits, Edit{
+			Start: oldStart,
+			End:   oldEnd,
+			New:   string(new[newStart:newEnd]),
+		})
+	}
+	return edits
+}
+
+// lineOffsets returns the byte offsets at which each line in x starts,
+// plus a final offset for the end of x.
+func lineOffsets(x []byte) []int {
+	offsets := []int{0}
+	for _, line := range Lines(x) {
+		offsets = append(offsets, offsets[len(offsets)-1]+len(line))
+	}
+	return offsets
+}
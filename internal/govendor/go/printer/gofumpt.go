@@ -0,0 +1,176 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// NOTE(gofumpt): this file is not vendored from Go; it is kept by
+// gen_govendor.go when re-vendoring, which also patches printer.go to call
+// measurer.wrote for every token and comment written to the output.
+
+package printer
+
+import (
+	"go/ast"
+	"go/token"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// A Span describes how a range of source code was rendered by the printer.
+// Widths and columns count each indentation tab as Tabwidth columns.
+// Any alignment padding between cells counts as a single column,
+// as the padding is only decided by the tabwriter after printing.
+type Span struct {
+	Line    int // output line where the range starts, counting from 1
+	EndLine int // output line where the range ends, counting from 1
+	Column  int // output column where the range starts, counting from 1
+
+	// Indent is the width of the indentation of the line where the range starts.
+	Indent int
+
+	// Width is the width that the range would take if its lines were joined
+	// with single spaces, without the indentation of any continuation lines.
+	Width int
+
+	// LineWidth is the width of the entire line where the range starts.
+	LineWidth int
+}
+
+// A Measurement holds the raw printer output for a node,
+// to measure how source ranges within the node were rendered.
+type Measurement struct {
+	tabwidth   int
+	output     []byte
+	lineStarts []int     // indices in output where each line starts
+	written    []written // sorted by source offset
+
+	// columns holds the width of the output before each index in its line,
+	// so that any width within a line can be found without scanning it.
+	columns []int
+}
+
+// written records that a token or comment at a source offset
+// was written as output[start:stop].
+type written struct {
+	offset      int
+	start, stop int
+}
+
+// measurer records what the printer writes.
+type measurer struct {
+	written []written
+}
+
+// wrote is called by writeString after s was written as output[start:stop],
+// where pos is the source position of s.
+func (m *measurer) wrote(pos token.Position, start, stop int) {
+	if pos.IsValid() {
+		m.written = append(m.written, written{pos.Offset, start, stop})
+	}
+}
+
+// Measure prints node like [Config.Fprint] without producing any output,
+// keeping what is needed to measure source ranges within node.
+//
+// Since node is usually a top-level declaration,
+// the measured spans account for the indentation and line breaks in node.
+func (cfg *Config) Measure(fset *token.FileSet, node any) (*Measurement, error) {
+	p := newPrinter(cfg, fset, make(map[ast.Node]int))
+	defer p.free()
+	p.measure = &measurer{}
+	if err := p.printNode(node); err != nil {
+		return nil, err
+	}
+	p.impliedSemi = false // EOF acts like a newline
+	p.flush(token.Position{Offset: infinity, Line: infinity}, token.EOF)
+
+	m := &Measurement{
+		tabwidth:   cfg.Tabwidth,
+		output:     slices.Clone(p.output), // p.output is reused by other printers
+		lineStarts: []int{0},
+		written:    p.measure.written,
+		columns:    make([]int, len(p.output)+1),
+	}
+	column, indenting := 0, true
+	for i, b := range m.output {
+		switch {
+		case isLineBreak(b):
+			m.lineStarts = append(m.lineStarts, i+1)
+			column, indenting = -1, true // the line break itself takes no width
+		case indenting && b == '\t':
+			column += m.tabwidth - 1
+		case b == tabwriter.Escape || !utf8.RuneStart(b):
+			// Cell separators like '\t' and '\v' count as one column of padding,
+			// but escapes and continuation bytes take no width.
+			indenting = false
+			column--
+		default:
+			indenting = false
+		}
+		column++
+		m.columns[i+1] = column
+	}
+	slices.SortStableFunc(m.written, func(a, b written) int {
+		return a.offset - b.offset
+	})
+	return m, nil
+}
+
+// Span reports how the source range from pos to end was rendered,
+// where pos and end are source offsets like [token.Position.Offset].
+// It reports false if no tokens or comments were printed from the range.
+func (m *Measurement) Span(pos, end int) (Span, bool) {
+	i := sort.Search(len(m.written), func(i int) bool { return m.written[i].offset >= pos })
+	j := sort.Search(len(m.written), func(i int) bool { return m.written[i].offset >= end })
+	if i >= j {
+		return Span{}, false
+	}
+	start, stop := m.written[i].start, m.written[j-1].stop
+
+	line := m.line(start)
+	lineStart, lineEnd := m.lineBounds(line)
+	span := Span{
+		Line:      line,
+		EndLine:   m.line(stop - 1),
+		Column:    1 + m.columns[start],
+		Indent:    leadingTabs(m.output[lineStart:lineEnd]) * m.tabwidth,
+		LineWidth: m.columns[lineEnd],
+	}
+
+	// Join the lines in the range, dropping their indentation.
+	from := start
+	for line := span.Line; line < span.EndLine; line++ {
+		_, end := m.lineBounds(line)
+		span.Width += m.columns[end] - m.columns[from] + 1
+		next, nextEnd := m.lineBounds(line + 1)
+		from = next + leadingTabs(m.output[next:nextEnd])
+	}
+	span.Width += m.columns[stop] - m.columns[from]
+	return span, true
+}
+
+// line returns the output line which holds the output index i, counting from 1.
+func (m *Measurement) line(i int) int {
+	return sort.Search(len(m.lineStarts), func(line int) bool { return m.lineStarts[line] > i })
+}
+
+// lineBounds returns the output indices where a line starts and ends,
+// excluding its line break.
+func (m *Measurement) lineBounds(line int) (start, end int) {
+	start, end = m.lineStarts[line-1], len(m.output)
+	if line < len(m.lineStarts) {
+		end = m.lineStarts[line] - 1
+	}
+	return start, end
+}
+
+func isLineBreak(b byte) bool { return b == '\n' || b == '\f' }
+
+// leadingTabs returns the number of indentation tabs at the start of line.
+func leadingTabs(line []byte) int {
+	n := 0
+	for n < len(line) && line[n] == '\t' {
+		n++
+	}
+	return n
+}
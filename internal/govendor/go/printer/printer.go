@@ -94,6 +94,8 @@ type printer struct {
 	// Cache of most recently computed line position.
 	cachedPos  token.Pos
 	cachedLine int // line corresponding to cachedPos
+
+	measure *measurer // NOTE(gofumpt): see gofumpt.go
 }
 
 func (p *printer) internalError(msg ...any) {
@@ -305,6 +307,9 @@ func (p *printer) writeString(pos token.Position, s string, isLit bool) {
 		p.output = append(p.output, fmt.Sprintf("/*%s*/", pos)...) // do not update p.pos!
 	}
 	p.output = append(p.output, s...)
+	if p.measure != nil { // NOTE(gofumpt): see gofumpt.go
+		p.measure.wrote(pos, len(p.output)-len(s), len(p.output))
+	}
 
 	// update positions
 	nlines := 0
@@ -0,0 +1,165 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package ignore matches paths against patterns in the syntax of gitignore
+// files, as used by .gofumptignore files and the gofumpt tool's -exclude flag.
+// See https://git-scm.com/docs/gitignore#_pattern_format.
+package ignore
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"mvdan.cc/gofumpt/internal/gomod"
+)
+
+// FileName is the name of the ignore files,
+// whose patterns apply to the directory they are in and its subdirectories.
+const FileName = ".gofumptignore"
+
+// A Pattern is a single pattern, relative to a directory.
+type Pattern struct {
+	dir      string   // the absolute directory the pattern is relative to
+	elems    []string // the slash-separated elements to match
+	negate   bool     // a leading "!" re-includes the matching paths
+	dirOnly  bool     // a trailing "/" only matches directories
+	anchored bool     // any other "/" matches relative to dir, not any name
+}
+
+// Parse parses a single pattern relative to the absolute directory dir.
+// It returns false if the line is blank or a comment, holding no pattern.
+func Parse(dir, line string) (Pattern, bool) {
+	// Trailing spaces are ignored unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return Pattern{}, false
+	}
+	p := Pattern{dir: dir}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return Pattern{}, false
+	}
+	p.elems = strings.Split(line, "/")
+	return p, true
+}
+
+// ParseFile parses the patterns in the contents of an ignore file,
+// relative to the absolute directory dir.
+func ParseFile(dir string, data []byte) []Pattern {
+	var patterns []Pattern
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimRight(line, "\r\n")
+		if p, ok := Parse(dir, line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// cachedPatternsByDir holds a []Pattern entry per directory.
+var cachedPatternsByDir sync.Map // map[dirString][]Pattern
+
+// Load returns the patterns which apply to the files in the absolute
+// directory dir, from the ignore files in dir and its parents,
+// with the patterns from the outermost directories first.
+// Like with gofumpt.toml files, the search stops at the root of the module
+// containing dir, so that an ignore file outside the module is never used.
+// Outside of a module, the search continues up to the filesystem root.
+// Results are cached per directory, and Load is safe for concurrent use.
+//
+// Ignore files which cannot be read are treated as if they were empty.
+func Load(dir string) []Pattern {
+	if cached, ok := cachedPatternsByDir.Load(dir); ok {
+		return cached.([]Pattern)
+	}
+	var patterns []Pattern
+	if mod := gomod.Load(dir); mod != nil && mod.Dir == dir {
+		// reached the module root
+	} else if parent := filepath.Dir(dir); parent != dir {
+		patterns = slices.Clip(Load(parent))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err == nil {
+		patterns = append(patterns, ParseFile(dir, data)...)
+	}
+	patterns = slices.Clip(patterns)
+	cachedPatternsByDir.Store(dir, patterns)
+	return patterns
+}
+
+// Match reports whether the absolute path, which is a directory if isDir is
+// true, is ignored by the patterns. Like in gitignore files, the last pattern
+// which matches the path decides whether it is ignored or re-included.
+//
+// Note that Match does not check the parent directories of path,
+// as a path whose parent directory is ignored is usually not reached.
+func Match(patterns []Pattern, path string, isDir bool) bool {
+	for _, p := range slices.Backward(patterns) {
+		if p.match(path, isDir) {
+			return !p.negate
+		}
+	}
+	return false
+}
+
+func (p *Pattern) match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(p.dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false // not under the pattern's directory
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	if !p.anchored {
+		// A pattern without a slash matches a name at any level.
+		return matchElems(p.elems, elems[len(elems)-1:])
+	}
+	return matchElems(p.elems, elems)
+}
+
+// matchElems matches path elements against pattern elements,
+// where "**" matches any number of path elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				// A trailing "/**" matches everything inside a directory.
+				return len(elems) > 0
+			}
+			for i := range len(elems) + 1 {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package ignore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.FromSlash("/root/dir")
+	tests := []struct {
+		patterns string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"foo.go", "foo.go", false, true},
+		{"foo.go", "sub/foo.go", false, true},
+		{"foo.go", "bar.go", false, false},
+		{"# foo.go", "foo.go", false, false},
+		{`\#foo.go`, "#foo.go", false, true},
+		{"foo.go   ", "foo.go", false, true},
+		{"*.pb.go", "sub/x.pb.go", false, true},
+		{"x?.go", "xy.go", false, true},
+		{"[a-c].go", "d.go", false, false},
+
+		{"/foo.go", "foo.go", false, true},
+		{"/foo.go", "sub/foo.go", false, false},
+		{"sub/foo.go", "sub/foo.go", false, true},
+		{"sub/foo.go", "other/sub/foo.go", false, false},
+
+		{"gen/", "gen", true, true},
+		{"gen/", "gen", false, false},
+		{"gen/", "sub/gen", true, true},
+
+		{"**/gen", "gen", true, true},
+		{"**/gen", "a/b/gen", true, true},
+		{"gen/**", "gen", true, false},
+		{"gen/**", "gen/a/b.go", false, true},
+		{"a/**/b.go", "a/b.go", false, true},
+		{"a/**/b.go", "a/x/y/b.go", false, true},
+
+		{"*.go\n!keep.go", "keep.go", false, false},
+		{"*.go\n!keep.go", "other.go", false, true},
+		{"!keep.go\n*.go", "keep.go", false, true},
+		{`\!keep.go`, "!keep.go", false, true},
+
+		{"foo.go", "../foo.go", false, false},
+		{"*", ".", true, false},
+	}
+	for _, test := range tests {
+		patterns := ParseFile(dir, []byte(test.patterns))
+		path := filepath.Join(dir, filepath.FromSlash(test.path))
+		got := Match(patterns, path, test.isDir)
+		qt.Check(t, qt.Equals(got, test.want), qt.Commentf("patterns %q, path %q", test.patterns, test.path))
+	}
+}
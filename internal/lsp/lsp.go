@@ -0,0 +1,448 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package lsp implements a minimal Language Server Protocol server over stdio,
+// used by `gofumpt -lsp` to format documents for editors.
+//
+// Only full document synchronization and the textDocument/formatting and
+// textDocument/rangeFormatting requests are supported.
+// See https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mvdan.cc/gofumpt/format"
+	"mvdan.cc/gofumpt/internal/govendor/diff"
+	goformat "mvdan.cc/gofumpt/internal/govendor/go/format"
+)
+
+// JSON-RPC and LSP error codes.
+const (
+	codeParseError           = -32700
+	codeMethodNotFound       = -32601
+	codeInvalidParams        = -32602
+	codeServerNotInitialized = -32002
+	codeRequestFailed        = -32803
+)
+
+// An OptionsFunc returns the options to format the Go file at path.
+type OptionsFunc func(path string) (format.Options, error)
+
+// An IgnoreFunc reports whether the Go file at path should be left alone,
+// such as when the gofumpt tool would skip it when walking directories.
+type IgnoreFunc func(path string) bool
+
+type server struct {
+	r       *bufio.Reader
+	w       io.Writer
+	options OptionsFunc
+	ignore  IgnoreFunc
+
+	initialized bool
+	shutdown    bool
+	utf8        bool // whether positions count UTF-8 bytes rather than UTF-16 units
+
+	docs map[string][]byte // open documents by URI
+}
+
+// Serve reads LSP messages from r and writes the responses to w,
+// until an exit notification is received or r reaches EOF.
+// The options for each document are resolved by calling options with
+// the document's file path, which may cache them across requests.
+// Documents for which ignore returns true are never edited, and, like the
+// gofumpt tool does when walking directories, generated documents are only
+// formatted as gofmt would. The ignore func may be nil.
+//
+// Serve returns an error if reading or writing messages fails,
+// or if the client exits without asking the server to shut down first.
+func Serve(r io.Reader, w io.Writer, options OptionsFunc, ignore IgnoreFunc) error {
+	s := &server{
+		r:       bufio.NewReader(r),
+		w:       w,
+		options: options,
+		ignore:  ignore,
+		docs:    make(map[string][]byte),
+	}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			if err := s.reply(nil, nil, &respError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit notification received before shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(&req)
+		if req.ID == nil {
+			continue // notifications don't get responses
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+type request struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *respError      `json:"error,omitempty"`
+}
+
+type respError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// read reads the content of the next message, skipping its headers.
+func (s *server) read() ([]byte, error) {
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading message header: %w", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(s.r, msg); err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *server) reply(id json.RawMessage, result any, rerr *respError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	resp := response{JSONRPC: "2.0", ID: id, Result: result, Error: rerr}
+	if rerr != nil {
+		resp.Result = nil
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// handle handles a request or notification,
+// returning either its result or an error.
+func (s *server) handle(req *request) (any, *respError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			Capabilities struct {
+				General struct {
+					PositionEncodings []string `json:"positionEncodings"`
+				} `json:"general"`
+			} `json:"capabilities"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		s.initialized = true
+		encoding := "utf-16"
+		for _, enc := range params.Capabilities.General.PositionEncodings {
+			if enc == "utf-8" {
+				s.utf8 = true
+				encoding = enc
+			}
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"positionEncoding":                encoding,
+				"textDocumentSync":                1, // full
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "gofumpt"},
+		}, nil
+	}
+	if !s.initialized {
+		return nil, &respError{codeServerNotInitialized, "server not initialized"}
+	}
+	switch req.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		s.docs[params.TextDocument.URI] = []byte(params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   textDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Range *rangeJSON `json:"range"`
+				Text  string     `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		// We only support full document synchronization,
+		// so the last change holds the entire document.
+		if n := len(params.ContentChanges); n > 0 {
+			change := params.ContentChanges[n-1]
+			if change.Range != nil {
+				return nil, &respError{codeInvalidParams, "incremental changes are not supported"}
+			}
+			s.docs[params.TextDocument.URI] = []byte(change.Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/formatting":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.format(params.TextDocument.URI, nil)
+	case "textDocument/rangeFormatting":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+			Range        rangeJSON    `json:"range"`
+		}
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+		return s.format(params.TextDocument.URI, &params.Range)
+	}
+	if strings.HasPrefix(req.Method, "$/") || req.ID == nil {
+		return nil, nil // optional notifications can be ignored
+	}
+	return nil, &respError{codeMethodNotFound, fmt.Sprintf("method not supported: %s", req.Method)}
+}
+
+type textDocument struct {
+	URI string `json:"uri"`
+}
+
+type rangeJSON struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textEdit struct {
+	Range   rangeJSON `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+func unmarshalParams(req *request, params any) *respError {
+	if len(req.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &respError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// format returns the edits to format an open document,
+// only within rng if it is non-nil.
+func (s *server) format(uri string, rng *rangeJSON) (any, *respError) {
+	src, ok := s.docs[uri]
+	if !ok {
+		return nil, &respError{codeInvalidParams, fmt.Sprintf("document not open: %s", uri)}
+	}
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil, &respError{codeInvalidParams, err.Error()}
+	}
+	lines := lineStarts(src)
+	start, end := 0, len(src)
+	if rng != nil {
+		var err error
+		if start, err = s.offset(src, lines, rng.Start); err != nil {
+			return nil, &respError{codeInvalidParams, err.Error()}
+		}
+		if end, err = s.offset(src, lines, rng.End); err != nil {
+			return nil, &respError{codeInvalidParams, err.Error()}
+		}
+		if start > end {
+			return nil, &respError{codeInvalidParams, "invalid range"}
+		}
+	}
+	result := []textEdit{} // an empty list rather than null
+	if s.ignore != nil && s.ignore(path) {
+		return result, nil
+	}
+	opts, err := s.options(path)
+	if err != nil {
+		return nil, &respError{codeRequestFailed, err.Error()}
+	}
+	var edits []format.Edit
+	switch {
+	case isGenerated(src):
+		var res []byte
+		if res, err = goformat.Source(src); err == nil {
+			for _, edit := range diff.Edits(src, res) {
+				if edit.End >= start && edit.Start <= end {
+					edits = append(edits, format.Edit(edit))
+				}
+			}
+		}
+	case rng == nil:
+		edits, err = format.Edits(src, opts)
+	default:
+		edits, err = format.RangeEdits(src, start, end, opts)
+	}
+	if err != nil {
+		return nil, &respError{codeRequestFailed, err.Error()}
+	}
+	for _, edit := range edits {
+		result = append(result, textEdit{
+			Range: rangeJSON{
+				Start: s.position(src, lines, edit.Start),
+				End:   s.position(src, lines, edit.End),
+			},
+			NewText: edit.New,
+		})
+	}
+	return result, nil
+}
+
+// lineStarts returns the byte offsets at which each line in src starts.
+func lineStarts(src []byte) []int {
+	starts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// position converts a byte offset in src into an LSP position.
+func (s *server) position(src []byte, lines []int, offset int) position {
+	line := sort.SearchInts(lines, offset+1) - 1
+	prefix := src[lines[line]:offset]
+	if s.utf8 {
+		return position{line, len(prefix)}
+	}
+	char := 0
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeRune(prefix)
+		prefix = prefix[size:]
+		char++
+		if r >= 0x10000 {
+			char++ // a surrogate pair in UTF-16
+		}
+	}
+	return position{line, char}
+}
+
+// isGenerated reports whether src has a comment marking it as generated
+// before its package clause. Invalid Go files are not generated.
+func isGenerated(src []byte) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly|parser.ParseComments)
+	return err == nil && ast.IsGenerated(file)
+}
+
+// offset converts an LSP position into a byte offset in src.
+// Positions past the end of a line are clamped, as per the specification,
+// but positions with negative values or past the last line are an error.
+func (s *server) offset(src []byte, lines []int, pos position) (int, error) {
+	if pos.Line < 0 || pos.Character < 0 || pos.Line >= len(lines) {
+		return 0, fmt.Errorf("invalid position: line %d, character %d", pos.Line, pos.Character)
+	}
+	offset := lines[pos.Line]
+	end := len(src)
+	if pos.Line+1 < len(lines) {
+		end = lines[pos.Line+1] - 1 // exclude the newline
+	}
+	for char := 0; char < pos.Character && offset < end; {
+		r, size := utf8.DecodeRune(src[offset:end])
+		offset += size
+		if s.utf8 {
+			char += size
+		} else if r >= 0x10000 {
+			char += 2
+		} else {
+			char++
+		}
+	}
+	return offset, nil
+}
+
+var rxWindowsDrive = regexp.MustCompile(`^/[a-zA-Z]:`)
+
+// uriToPath converts a file URI into a file path.
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI scheme: %q", uri)
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" && rxWindowsDrive.MatchString(path) {
+		path = path[1:] // "/C:/foo" to "C:/foo"
+	}
+	return filepath.FromSlash(path), nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"mvdan.cc/gofumpt/format"
+)
+
+type testEdit struct {
+	Range   rangeJSON `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+type testResp struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *respError      `json:"error"`
+}
+
+// writeMsg writes a message to in, which is a notification if id is zero.
+func writeMsg(t *testing.T, in *bytes.Buffer, id int, method string, params any) {
+	msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+	if id > 0 {
+		msg["id"] = id
+	}
+	data, err := json.Marshal(msg)
+	qt.Assert(t, qt.IsNil(err))
+	fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// readResps reads all the responses written to out.
+func readResps(t *testing.T, out *bytes.Buffer) []testResp {
+	var resps []testResp
+	r := bufio.NewReader(out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		qt.Assert(t, qt.IsNil(err))
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		qt.Assert(t, qt.IsNil(err))
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		qt.Assert(t, qt.IsNil(err))
+		var rs testResp
+		qt.Assert(t, qt.IsNil(json.Unmarshal(data, &rs)))
+		resps = append(resps, rs)
+	}
+	return resps
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	qt.Assert(t, qt.IsNil(os.WriteFile(filepath.Join(dir, "go.mod"),
+		[]byte("module example.com/foo\n\ngo 1.13\n"), 0o666)))
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "foo.go"))
+
+	src := "package p\n\nfunc f() {\n\n\tprintln(\"é\", 0755)\n}\n\nfunc g() {\n\n\tprintln(\"g\")\n}\n"
+	var in bytes.Buffer
+	write := func(id int, method string, params any) {
+		writeMsg(t, &in, id, method, params)
+	}
+	rangeParams := func(startLine, startChar, endLine, endChar int) map[string]any {
+		return map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			"range": map[string]any{
+				"start": map[string]any{"line": startLine, "character": startChar},
+				"end":   map[string]any{"line": endLine, "character": endChar},
+			},
+		}
+	}
+	write(1, "textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	write(2, "initialize", map[string]any{})
+	write(0, "initialized", map[string]any{})
+	write(0, "textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "text": "package p\n"}})
+	write(0, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri},
+		"contentChanges": []any{map[string]any{"text": src}},
+	})
+	write(3, "textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	write(4, "textDocument/rangeFormatting", rangeParams(7, 0, 10, 1))
+	write(5, "unknown/method", nil)
+	write(6, "textDocument/rangeFormatting", rangeParams(-1, 0, 1, 0))
+	write(7, "textDocument/rangeFormatting", rangeParams(0, 0, 20, 0))
+	write(8, "shutdown", nil)
+	write(0, "exit", nil)
+
+	var out bytes.Buffer
+	err := Serve(&in, &out, format.OptionsForFile, nil)
+	qt.Assert(t, qt.IsNil(err))
+
+	resps := readResps(t, &out)
+	qt.Assert(t, qt.HasLen(resps, 8))
+
+	qt.Assert(t, qt.Equals(resps[0].ID, 1))
+	qt.Assert(t, qt.Equals(resps[0].Error.Code, codeServerNotInitialized))
+
+	qt.Assert(t, qt.Equals(resps[1].ID, 2))
+	qt.Assert(t, qt.IsNil(resps[1].Error))
+
+	// The octal literal requires go1.13 from go.mod,
+	// and the columns after "é" count UTF-16 units.
+	var edits []testEdit
+	qt.Assert(t, qt.IsNil(json.Unmarshal(resps[2].Result, &edits)))
+	qt.Assert(t, qt.DeepEquals(edits, []testEdit{
+		{rangeJSON{position{3, 0}, position{4, 15}}, "\tprintln(\"é\", 0o"},
+		{rangeJSON{position{8, 0}, position{9, 0}}, ""},
+	}))
+
+	// Only the second function is in the range.
+	qt.Assert(t, qt.IsNil(json.Unmarshal(resps[3].Result, &edits)))
+	qt.Assert(t, qt.DeepEquals(edits, []testEdit{
+		{rangeJSON{position{8, 0}, position{9, 0}}, ""},
+	}))
+
+	qt.Assert(t, qt.Equals(resps[4].Error.Code, codeMethodNotFound))
+
+	// Negative positions and lines past the end of the document are invalid.
+	qt.Assert(t, qt.Equals(resps[5].Error.Code, codeInvalidParams))
+	qt.Assert(t, qt.Equals(resps[6].Error.Code, codeInvalidParams))
+
+	qt.Assert(t, qt.IsNil(resps[7].Error))
+}
+
+func TestServeGeneratedIgnored(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	generated := "file://" + filepath.ToSlash(filepath.Join(dir, "generated.go"))
+	ignored := "file://" + filepath.ToSlash(filepath.Join(dir, "ignored.go"))
+
+	// gofmt would indent the statement, and gofumpt would remove the empty line.
+	src := "package p\n\nfunc f() {\n\nprintln()\n}\n"
+	var in bytes.Buffer
+	write := func(id int, method string, params any) {
+		writeMsg(t, &in, id, method, params)
+	}
+	write(1, "initialize", map[string]any{})
+	write(0, "textDocument/didOpen", map[string]any{"textDocument": map[string]any{
+		"uri": generated, "text": "// Code generated by foo. DO NOT EDIT.\n\n" + src,
+	}})
+	write(0, "textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": ignored, "text": src}})
+	write(2, "textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": generated}})
+	write(3, "textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": ignored}})
+	write(4, "shutdown", nil)
+	write(0, "exit", nil)
+
+	var out bytes.Buffer
+	ignore := func(path string) bool { return filepath.Base(path) == "ignored.go" }
+	err := Serve(&in, &out, format.OptionsForFile, ignore)
+	qt.Assert(t, qt.IsNil(err))
+
+	resps := readResps(t, &out)
+	qt.Assert(t, qt.HasLen(resps, 4))
+
+	// Generated documents are only formatted as gofmt would.
+	var edits []testEdit
+	qt.Assert(t, qt.IsNil(json.Unmarshal(resps[1].Result, &edits)))
+	qt.Assert(t, qt.DeepEquals(edits, []testEdit{
+		{rangeJSON{position{6, 0}, position{6, 0}}, "\t"},
+	}))
+
+	// Ignored documents are left alone.
+	qt.Assert(t, qt.IsNil(json.Unmarshal(resps[2].Result, &edits)))
+	qt.Assert(t, qt.HasLen(edits, 0))
+}
+
+func TestServeExitWithoutShutdown(t *testing.T) {
+	t.Parallel()
+
+	in := bytes.NewBufferString("Content-Length: 17\r\n\r\n{\"method\":\"exit\"}")
+	err := Serve(in, io.Discard, format.OptionsForFile, nil)
+	qt.Assert(t, qt.ErrorMatches(err, "exit notification received before shutdown"))
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strings"
 )
 
 const ourModulePath = "mvdan.cc/gofumpt"
@@ -55,3 +56,14 @@ func String(injected string) string {
 	}
 	return fmt.Sprintf("%s (%s)", gofumptVersion(), goVersion())
 }
+
+// IsRelease reports whether the version from [String] identifies
+// a build of gofumpt's source code, rather than a development build
+// from a source tree which may have changed since.
+func IsRelease(injected string) bool {
+	if injected != "" {
+		return true
+	}
+	v := gofumptVersion()
+	return strings.HasPrefix(v, "v") && !strings.HasSuffix(v, "+dirty")
+}
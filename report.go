@@ -0,0 +1,435 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	gformat "mvdan.cc/gofumpt/format"
+	"mvdan.cc/gofumpt/internal/formatinternal"
+	"mvdan.cc/gofumpt/internal/govendor/diff"
+	gversion "mvdan.cc/gofumpt/internal/version"
+)
+
+// errReported is returned by processFile when an error was already included
+// in a file's report, so the reporter only needs to set the exit code.
+var errReported = errors.New("error already reported")
+
+// fileReport is the JSON object printed for each file with -format=json.
+// With -format=sarif, it is added to a single SARIF log instead.
+type fileReport struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+
+	// The effective options used to format the file.
+	Lang    string   `json:"lang,omitempty"`
+	ModPath string   `json:"modpath,omitempty"`
+	Extra   []string `json:"extra,omitempty"`
+	Disable []string `json:"disable,omitempty"`
+
+	Errors []reportError `json:"errors,omitempty"`
+	Hunks  []reportHunk  `json:"hunks,omitempty"`
+
+	// For SARIF's results; see [fileReport.setDiagnostics].
+	oldLines int
+	diags    []gformat.Diagnostic
+}
+
+// reportError is an error encountered while processing a file,
+// such as a parse error. Line and Column are zero if unknown.
+type reportError struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// reportHunk is a changed range of lines, like in a unified diff.
+// The start lines count from 1, and Old and New hold the replaced lines.
+type reportHunk struct {
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+
+	// The byte offset range of the old lines, for SARIF's replacements.
+	offset, length int
+}
+
+// setOptions records the options used to format file, whose language version
+// may be set by its build constraint. The file may only hold its header.
+func (rep *fileReport) setOptions(opts gformat.Options, file *ast.File) {
+	rep.Lang = formatinternal.FileLangVersion(file, opts.LangVersion)
+	rep.ModPath = opts.ModulePath
+	if s := opts.Extra.String(); s != "" {
+		rep.Extra = strings.Split(s, ",")
+	}
+	if s := opts.Disable.String(); s != "" {
+		rep.Disable = strings.Split(s, ",")
+	}
+}
+
+func (rep *fileReport) setResult(src, res []byte) {
+	oldLines := diff.Lines(src)
+	newLines := diff.Lines(res)
+	rep.oldLines = len(oldLines)
+	offset, line := 0, 0 // the byte offset where each old line starts
+	for _, h := range diff.Hunks(src, res) {
+		for ; line < h.OldStart; line++ {
+			offset += len(oldLines[line])
+		}
+		old := strings.Join(oldLines[h.OldStart:h.OldEnd], "")
+		rep.Changed = true
+		rep.Hunks = append(rep.Hunks, reportHunk{
+			OldStart: h.OldStart + 1,
+			OldLines: h.OldEnd - h.OldStart,
+			NewStart: h.NewStart + 1,
+			NewLines: h.NewEnd - h.NewStart,
+			Old:      old,
+			New:      strings.Join(newLines[h.NewStart:h.NewEnd], ""),
+
+			offset: offset,
+			length: len(old),
+		})
+	}
+}
+
+// setDiagnostics records the changes made by gofumpt's rules, as reported by
+// [gformat.Check], so that each SARIF result can name the rules behind it.
+func (rep *fileReport) setDiagnostics(diags []gformat.Diagnostic) {
+	rep.diags = diags
+}
+
+// explain returns the rules whose diagnostics overlap with the hunk's old lines,
+// or with the lines around it if lines are only inserted, like [explainDiff],
+// as well as the explanation for each of them.
+func (h *reportHunk) explain(diags []gformat.Diagnostic) (rules, explained []string) {
+	first, last := h.OldStart, h.OldStart+h.OldLines-1
+	if h.OldLines == 0 {
+		first, last = h.OldStart-1, h.OldStart
+	}
+	for _, diag := range diags {
+		if diag.Pos.Line > last || diag.End.Line < first {
+			continue
+		}
+		s := fmt.Sprintf("%s (%s)", diag.Message, diag.Rule)
+		if !slices.Contains(explained, s) {
+			explained = append(explained, s)
+		}
+		if !slices.Contains(rules, diag.Rule) {
+			rules = append(rules, diag.Rule)
+		}
+	}
+	return rules, explained
+}
+
+// write prints the report as a single line of JSON, or adds it to the
+// SARIF log with -format=sarif, including err as the file's errors if it is
+// not nil. The returned error replaces err, as it is already part of the report.
+func (rep *fileReport) write(r *reporter, err error) error {
+	// With -check, the report already says that the file changed.
+	differs := err == errFormattingDiffers
+	if differs {
+		err = nil
+	}
+	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				rep.Errors = append(rep.Errors, reportError{e.Pos.Line, e.Pos.Column, e.Msg})
+			}
+		} else {
+			rep.Errors = append(rep.Errors, reportError{Message: err.Error()})
+		}
+	}
+	if *outputFormat == "sarif" {
+		// The reporter state is handed from one file to the next in order,
+		// so the SARIF log holds the files in a deterministic order.
+		r.getState().sarif.add(rep)
+		return rep.result(err, differs)
+	}
+	data, merr := json.Marshal(rep)
+	if merr != nil {
+		return merr
+	}
+	if _, werr := fmt.Fprintf(r, "%s\n", data); werr != nil {
+		return werr
+	}
+	return rep.result(err, differs)
+}
+
+// result returns the error that processFile should return after a report.
+func (rep *fileReport) result(err error, differs bool) error {
+	switch {
+	case err != nil:
+		return errReported
+	case differs:
+		return errFormattingDiffers
+	}
+	return nil
+}
+
+// sarifLog collects the reports for all files into a single SARIF 2.1.0 log,
+// which is printed once all files have been processed with -format=sarif.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type sarifLog struct {
+	results       []sarifResult
+	notifications []sarifNotification
+}
+
+// sarifRuleID is the rule for the results with changes made by gofmt itself,
+// as the results with changes made by gofumpt's rules use their names.
+const sarifRuleID = "gofumpt"
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	Fixes     []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifRegion is either a range of lines and columns, or a range of bytes.
+type sarifRegion struct {
+	StartLine   int  `json:"startLine,omitempty"`
+	StartColumn int  `json:"startColumn,omitempty"`
+	EndLine     int  `json:"endLine,omitempty"`
+	ByteOffset  *int `json:"byteOffset,omitempty"`
+	ByteLength  *int `json:"byteLength,omitempty"`
+}
+
+type sarifFix struct {
+	Description     sarifMessage          `json:"description"`
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Replacements     []sarifReplacement    `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+// sarifURI returns the SARIF artifact URI for a file path,
+// which is relative to the current directory unless path is absolute.
+func sarifURI(path string) string {
+	if filepath.IsAbs(path) {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(path)}).String()
+}
+
+func (l *sarifLog) add(rep *fileReport) {
+	artifact := sarifArtifactLocation{URI: sarifURI(rep.Path)}
+	for _, e := range rep.Errors {
+		loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: artifact}}
+		if e.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: e.Line, StartColumn: e.Column}
+		}
+		l.notifications = append(l.notifications, sarifNotification{
+			Level:     "error",
+			Message:   sarifMessage{e.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+	for _, h := range rep.Hunks {
+		// Lines which are only inserted are reported at the line after them,
+		// or at the last line if they are inserted at the end of the file.
+		region := &sarifRegion{StartLine: h.OldStart, EndLine: h.OldStart + h.OldLines - 1}
+		if h.OldLines == 0 {
+			region.StartLine = max(min(region.StartLine, rep.oldLines), 1)
+			region.EndLine = region.StartLine
+		}
+		message := fmt.Sprintf("lines %d-%d are not gofumpt-ed", region.StartLine, region.EndLine)
+		if region.StartLine == region.EndLine {
+			message = fmt.Sprintf("line %d is not gofumpt-ed", region.StartLine)
+		}
+		// A hunk changed by multiple rules is reported under the first one.
+		ruleID := sarifRuleID
+		if rules, explained := h.explain(rep.diags); len(rules) > 0 {
+			ruleID = rules[0]
+			message += ": " + strings.Join(explained, "; ")
+		}
+		l.results = append(l.results, sarifResult{
+			RuleID:  ruleID,
+			Level:   "warning",
+			Message: sarifMessage{message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: artifact,
+				Region:           region,
+			}}},
+			Fixes: []sarifFix{{
+				Description: sarifMessage{"Format with gofumpt"},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: artifact,
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   sarifRegion{ByteOffset: &h.offset, ByteLength: &h.length},
+						InsertedContent: sarifMessage{h.New},
+					}},
+				}},
+			}},
+		})
+	}
+}
+
+// write prints the SARIF log as indented JSON.
+func (l *sarifLog) write(w io.Writer) error {
+	results := l.results
+	if results == nil {
+		results = []sarifResult{} // SARIF requires an array rather than null
+	}
+	invocation := map[string]any{"executionSuccessful": len(l.notifications) == 0}
+	if len(l.notifications) > 0 {
+		invocation["toolExecutionNotifications"] = l.notifications
+	}
+	log := map[string]any{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":           "gofumpt",
+				"informationUri": "https://github.com/mvdan/gofumpt",
+				"version":        gversion.String(version),
+				"rules":          sarifRules(),
+			}},
+			"invocations": []any{invocation},
+			"results":     results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// sarifRules returns the rules for the tool's driver: one per gofumpt rule,
+// plus sarifRuleID for the changes made by gofmt itself.
+func sarifRules() []any {
+	rules := []any{map[string]any{
+		"id":               sarifRuleID,
+		"shortDescription": sarifMessage{"Go files should be formatted with gofumpt"},
+		"helpUri":          "https://github.com/mvdan/gofumpt#added-rules",
+	}}
+	var all gformat.Rules
+	all.Set("true")
+	for _, name := range strings.Split(all.String(), ",") {
+		rules = append(rules, map[string]any{
+			"id":      name,
+			"helpUri": "https://github.com/mvdan/gofumpt#added-rules",
+		})
+	}
+	return rules
+}
+
+var rxHunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// explainDiff annotates each hunk header in a unified diff with the rules
+// whose diagnostics overlap with the hunk's removed or inserted lines,
+// such as "@@ -3,7 +3,7 @@ std imports grouped (std-imports)".
+// Text after a hunk header is ignored by tools like patch.
+// Hunks only changed by gofmt itself are not annotated.
+func explainDiff(d []byte, diags []gformat.Diagnostic) []byte {
+	var buf bytes.Buffer
+	var header string
+	var hunk []string
+	changed := make(map[int]bool) // changed lines in the old text
+	flush := func() {
+		if header == "" {
+			return
+		}
+		buf.WriteString(header)
+		var explained []string
+		for _, diag := range diags {
+			for line := diag.Pos.Line; line <= diag.End.Line; line++ {
+				if changed[line] {
+					s := fmt.Sprintf("%s (%s)", diag.Message, diag.Rule)
+					if !slices.Contains(explained, s) {
+						explained = append(explained, s)
+					}
+					break
+				}
+			}
+		}
+		if len(explained) > 0 {
+			buf.WriteString(" " + strings.Join(explained, "; "))
+		}
+		buf.WriteString("\n")
+		for _, line := range hunk {
+			buf.WriteString(line)
+		}
+		header, hunk = "", nil
+		clear(changed)
+	}
+	oldLine := 0
+	for _, line := range diff.Lines(d) {
+		if m := rxHunkHeader.FindStringSubmatch(line); m != nil {
+			flush()
+			header = strings.TrimSuffix(line, "\n")
+			oldLine, _ = strconv.Atoi(m[1])
+			if strings.Contains(m[0], ",0 +") {
+				oldLine++ // an empty old range starts before the given line
+			}
+			continue
+		}
+		if header == "" {
+			buf.WriteString(line) // the file headers
+			continue
+		}
+		hunk = append(hunk, line)
+		switch {
+		case strings.HasPrefix(line, "-"):
+			changed[oldLine] = true
+			oldLine++
+		case strings.HasPrefix(line, "+"):
+			// Inserted lines go between two old lines.
+			changed[oldLine-1] = true
+			changed[oldLine] = true
+		default:
+			oldLine++
+		}
+	}
+	flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2026, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagedInfo describes a Go file's blob as staged in the git index,
+// which is formatted with -staged instead of the file in the work tree.
+type stagedInfo struct {
+	path    string // relative to the current directory
+	gitMode string // such as "100644"
+	hash    string
+	size    int64
+}
+
+func (s *stagedInfo) Name() string       { return filepath.Base(s.path) }
+func (s *stagedInfo) Size() int64        { return s.size }
+func (s *stagedInfo) ModTime() time.Time { return time.Time{} }
+func (s *stagedInfo) IsDir() bool        { return false }
+func (s *stagedInfo) Sys() any           { return nil }
+
+func (s *stagedInfo) Mode() fs.FileMode {
+	if s.gitMode == "100755" {
+		return 0o755
+	}
+	return 0o644
+}
+
+// stagedFiles returns the Go files under the current directory whose
+// contents are staged to be added or modified in the next commit,
+// optionally limited to the given paths.
+func stagedFiles(paths []string) ([]*stagedInfo, error) {
+	args := []string{"diff", "--cached", "--raw", "-z", "--no-abbrev", "--relative", "--diff-filter=ACMR", "--"}
+	out, err := runGit(".", append(args, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	// Each entry is ":oldmode newmode oldhash newhash status\x00path\x00",
+	// where renames and copies have both the old and new path.
+	var files []*stagedInfo
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) != 5 {
+			return nil, fmt.Errorf("unexpected git diff output: %q", fields[i])
+		}
+		if meta[4][0] == 'R' || meta[4][0] == 'C' {
+			i++ // skip the old path
+		}
+		path := fields[i+1]
+		gitMode, hash := meta[1], meta[3]
+		if gitMode != "100644" && gitMode != "100755" {
+			continue // e.g. a symbolic link or submodule
+		}
+		if !isGoFilename(filepath.Base(path)) || shouldIgnoreFile(path) {
+			continue
+		}
+		size, err := runGit(".", "cat-file", "-s", hash)
+		if err != nil {
+			return nil, err
+		}
+		file := &stagedInfo{path: filepath.FromSlash(path), gitMode: gitMode, hash: hash}
+		if _, err := fmt.Sscan(string(size), &file.size); err != nil {
+			return nil, fmt.Errorf("unexpected git cat-file output: %q", size)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// read returns the contents of the staged blob.
+func (s *stagedInfo) read() ([]byte, error) {
+	return runGit(".", "cat-file", "blob", s.hash)
+}
+
+// indexMu serializes updates to the git index, which is locked while updating.
+var indexMu sync.Mutex
+
+// write stages the formatted contents of a file in place of orig.
+// The file in the work tree is also updated if it matches orig,
+// meaning that the file had no unstaged changes which we could lose.
+func (s *stagedInfo) write(orig, formatted []byte) error {
+	out, err := runGitInput(".", formatted, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	hash := strings.TrimSpace(string(out))
+	indexMu.Lock()
+	_, err = runGit(".", "update-index", "--cacheinfo", s.gitMode+","+hash+","+filepath.ToSlash(s.path))
+	indexMu.Unlock()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil // e.g. removed from the work tree after being staged
+	}
+	current, err := readFile(s.path, info, nil)
+	if err != nil || !bytes.Equal(current, orig) {
+		return nil // partially staged; leave the unstaged changes alone
+	}
+	return writeFile(s.path, orig, formatted, info.Mode().Perm(), info.Size())
+}